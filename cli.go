@@ -9,11 +9,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	appcore "github.com/jackhorton/veil/internal/app"
 	"github.com/jackhorton/veil/internal/tui"
+	"github.com/skip2/go-qrcode"
 )
 
 func runCLI(args []string) error {
@@ -48,8 +51,26 @@ func runCLI(args []string) error {
 		return cmdLS(application, args[1:])
 	case "rm":
 		return cmdRM(application, args[1:])
+	case "include":
+		return cmdInclude(application, args[1:])
 	case "link":
 		return cmdLink(application, args[1:])
+	case "machines":
+		return cmdMachines(application, args[1:])
+	case "rotate-key":
+		return cmdRotateKey(application, args[1:])
+	case "config":
+		return cmdConfig(application, args[1:])
+	case "doctor":
+		return cmdDoctor(application)
+	case "status":
+		return cmdStatus(application)
+	case "history":
+		return cmdHistory(application, args[1:])
+	case "rollback":
+		return cmdRollback(application, args[1:])
+	case "recovery":
+		return cmdRecovery(application, args[1:])
 	default:
 		return fmt.Errorf("unknown command %q (run `veil --help`)", args[0])
 	}
@@ -59,7 +80,7 @@ func cmdInit(app *appcore.App, args []string) error {
 	args = reorderFlags(args, map[string]bool{"--key-storage": true, "--machine-name": true, "--link": false})
 	fs := flag.NewFlagSet("init", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
-	keyStorage := fs.String("key-storage", "file", "key storage backend: file or keychain")
+	keyStorage := fs.String("key-storage", "file", "key storage backend: file, keychain or passphrase")
 	machineName := fs.String("machine-name", "", "machine name")
 	link := fs.Bool("link", false, "create/link GitHub gist after init")
 	if err := fs.Parse(args); err != nil {
@@ -69,26 +90,57 @@ func cmdInit(app *appcore.App, args []string) error {
 		return err
 	}
 	if *link {
-		if err := app.Link("", ""); err != nil {
+		if err := app.Link(appcore.LinkOptions{}); err != nil {
 			return err
 		}
 	}
 	fmt.Printf("Initialized Veil at %s\n", app.HomeDir)
+	if *link {
+		return printPendingHint(app)
+	}
+	return nil
+}
+
+// printPendingHint points at the machines a fresh link found on the remote;
+// they are not trusted until approved here.
+func printPendingHint(app *appcore.App) error {
+	pending, err := app.PendingRecipients()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	fmt.Printf("%d machine key(s) on the remote are pending; review them with `veil machines` and trust them with `veil machines approve`\n", len(pending))
 	return nil
 }
 
 func cmdSet(app *appcore.App, args []string) error {
-	args = reorderFlags(args, map[string]bool{"-p": true, "--group": true})
+	args = reorderFlags(args, withEnvFlags(map[string]bool{"-p": true, "--group": true, "--desc": true, "--tags": true, "--source": true, "--expires": true, "--expand": false}))
 	fs := flag.NewFlagSet("set", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
 	group := fs.String("group", "", "group label override")
+	desc := fs.String("desc", "", "description (empty clears it)")
+	tags := fs.String("tags", "", "comma separated tags (empty clears them)")
+	source := fs.String("source", "", "URL of the dashboard that issued the key (empty clears it)")
+	expires := fs.String("expires", "", "expiry date, YYYY-MM-DD or RFC 3339 (empty clears it)")
+	expand := fs.Bool("expand", false, "expand ${KEY} references in the value when rendered (--expand=false turns it off)")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	metaFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "desc", "tags", "source", "expires", "expand":
+			metaFlags[f.Name] = true
+		}
+	})
 	remaining := fs.Args()
-	if len(remaining) < 2 {
-		return errors.New("usage: veil set KEY VALUE [-p project] [--group group]")
+	if len(remaining) < 2 && (len(remaining) < 1 || len(metaFlags) == 0) {
+		return errors.New("usage: veil set KEY [VALUE] [-p project] [-e env] [--group group] [--desc text] [--tags a,b] [--source URL] [--expires DATE] [--expand]")
+	}
+	env, err := appcore.NormalizeEnv(*envFlag)
+	if err != nil {
+		return err
 	}
 	project, path, err := app.ResolveProject(*projectFlag)
 	if err != nil {
@@ -99,30 +151,65 @@ func cmdSet(app *appcore.App, args []string) error {
 		return err
 	}
 	key := remaining[0]
-	value := strings.Join(remaining[1:], " ")
-	created := appcore.UpsertSecret(bundle, key, value, *group)
+	created := false
+	if len(remaining) > 1 {
+		created = appcore.UpsertSecret(bundle, env, key, strings.Join(remaining[1:], " "), *group)
+	} else if _, ok := appcore.GetSecret(bundle, env, key); !ok {
+		return fmt.Errorf("key %q not found in project %q (pass a VALUE to add it)", key, projectLabel(project, env))
+	}
+	if metaFlags["expand"] {
+		if _, err := appcore.SetSecretExpand(bundle, env, key, *expand); err != nil {
+			return err
+		}
+		delete(metaFlags, "expand")
+	}
+	if len(metaFlags) > 0 {
+		secret, _ := appcore.GetSecret(bundle, env, key)
+		meta := appcore.MetadataOf(secret)
+		if metaFlags["desc"] {
+			meta.Description = *desc
+		}
+		if metaFlags["tags"] {
+			meta.Tags = appcore.ParseTags(*tags)
+		}
+		if metaFlags["source"] {
+			meta.Source = *source
+		}
+		if metaFlags["expires"] {
+			meta.ExpiresAt = *expires
+		}
+		if _, err := appcore.SetSecretMetadata(bundle, env, key, meta); err != nil {
+			return err
+		}
+	}
 	if err := app.SaveProject(bundle); err != nil {
 		return err
 	}
 	if created {
-		fmt.Printf("Added %s to %s\n", key, project)
+		fmt.Printf("Added %s to %s\n", key, projectLabel(project, env))
 	} else {
-		fmt.Printf("Updated %s in %s\n", key, project)
+		fmt.Printf("Updated %s in %s\n", key, projectLabel(project, env))
 	}
 	return nil
 }
 
 func cmdGet(app *appcore.App, args []string) error {
-	args = reorderFlags(args, map[string]bool{"-p": true})
+	args = reorderFlags(args, withEnvFlags(map[string]bool{"-p": true, "--resolve": false}))
 	fs := flag.NewFlagSet("get", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
+	expand := fs.Bool("resolve", false, "expand ${KEY} references")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	remaining := fs.Args()
 	if len(remaining) < 1 {
-		return errors.New("usage: veil get KEY [-p project]")
+		return errors.New("usage: veil get KEY [-p project] [-e env] [--resolve]")
+	}
+	env, err := appcore.NormalizeEnv(*envFlag)
+	if err != nil {
+		return err
 	}
 	project, path, err := app.ResolveProject(*projectFlag)
 	if err != nil {
@@ -132,29 +219,45 @@ func cmdGet(app *appcore.App, args []string) error {
 	if err != nil {
 		return err
 	}
-	secret, ok := appcore.GetSecret(bundle, remaining[0])
-	if !ok {
-		return fmt.Errorf("key %q not found in project %q", remaining[0], project)
+	if err := app.CheckEnv(bundle, env); err != nil {
+		return err
 	}
-	fmt.Println(secret.Value)
-	return nil
+	resolve := app.ResolveSecrets
+	if *expand {
+		resolve = app.ExpandedSecrets
+	}
+	resolved, err := resolve(bundle, env)
+	if err != nil {
+		return err
+	}
+	for _, secret := range resolved {
+		if secret.Key == remaining[0] {
+			fmt.Println(secret.Value)
+			return nil
+		}
+	}
+	return fmt.Errorf("key %q not found in project %q", remaining[0], projectLabel(project, env))
 }
 
 func cmdImport(app *appcore.App, args []string) error {
-	args = reorderFlags(args, map[string]bool{"-p": true, "--skip-existing": false})
+	args = reorderFlags(args, withEnvFlags(map[string]bool{"-p": true, "--skip-existing": false}))
 	fs := flag.NewFlagSet("import", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
 	skipExisting := fs.Bool("skip-existing", false, "skip duplicate keys")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	remaining := fs.Args()
 	if len(remaining) < 1 {
-		return errors.New("usage: veil import FILE|- [-p project] [--skip-existing]")
+		return errors.New("usage: veil import FILE|- [-p project] [-e env] [--skip-existing] (a .env file or a JSON export)")
+	}
+	env, err := appcore.NormalizeEnv(*envFlag)
+	if err != nil {
+		return err
 	}
 	var raw []byte
-	var err error
 	if remaining[0] == "-" {
 		raw, err = io.ReadAll(os.Stdin)
 	} else {
@@ -163,7 +266,10 @@ func cmdImport(app *appcore.App, args []string) error {
 	if err != nil {
 		return err
 	}
-	pairs, err := appcore.ParseEnvContent(string(raw))
+	if env != "" && appcore.IsProjectJSON(string(raw)) {
+		return errors.New("a JSON export keeps each secret's environment; import it without -e")
+	}
+	secrets, err := appcore.ParseImport(string(raw), env)
 	if err != nil {
 		return err
 	}
@@ -175,15 +281,28 @@ func cmdImport(app *appcore.App, args []string) error {
 	if err != nil {
 		return err
 	}
+	includes := []string{}
+	for _, name := range appcore.ImportedIncludes(string(raw)) {
+		if slices.Contains(bundle.Includes, name) {
+			continue
+		}
+		if err := app.AddInclude(bundle, name); err != nil {
+			return err
+		}
+		includes = append(includes, name)
+	}
 	added := 0
 	updated := 0
 	skipped := 0
-	for _, pair := range pairs {
-		if _, exists := appcore.GetSecret(bundle, pair.Key); exists && *skipExisting {
+	for _, secret := range secrets {
+		if _, exists := appcore.GetSecret(bundle, secret.Env, secret.Key); exists && *skipExisting {
 			skipped++
 			continue
 		}
-		created := appcore.UpsertSecret(bundle, pair.Key, pair.Value, "")
+		created, err := appcore.ImportSecret(bundle, secret)
+		if err != nil {
+			return err
+		}
 		if created {
 			added++
 		} else {
@@ -193,20 +312,28 @@ func cmdImport(app *appcore.App, args []string) error {
 	if err := app.SaveProject(bundle); err != nil {
 		return err
 	}
-	fmt.Printf("Imported %d keys (%d added, %d updated, %d skipped) into %s\n", len(pairs), added, updated, skipped, project)
+	fmt.Printf("Imported %d keys (%d added, %d updated, %d skipped) into %s\n", len(secrets), added, updated, skipped, projectLabel(project, env))
+	if len(includes) > 0 {
+		fmt.Printf("Now including %s\n", strings.Join(includes, ", "))
+	}
 	return nil
 }
 
 func cmdExport(app *appcore.App, args []string) error {
-	args = reorderFlags(args, map[string]bool{"--format": true, "--out": true, "-p": true})
+	args = reorderFlags(args, withEnvFlags(map[string]bool{"--format": true, "--out": true, "-p": true}))
 	fs := flag.NewFlagSet("export", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	format := fs.String("format", "", "export format: env|json")
 	outPath := fs.String("out", "", "output path (stdout when omitted)")
 	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	env, err := appcore.NormalizeEnv(*envFlag)
+	if err != nil {
+		return err
+	}
 	remaining := fs.Args()
 	project := ""
 	if len(remaining) > 0 {
@@ -233,8 +360,20 @@ func cmdExport(app *appcore.App, args []string) error {
 	var rendered string
 	switch selectedFormat {
 	case "env":
-		rendered = appcore.RenderEnv(bundle)
+		if err := app.CheckEnv(bundle, env); err != nil {
+			return err
+		}
+		view, err := app.ResolvedBundle(bundle, env)
+		if err != nil {
+			return err
+		}
+		rendered = appcore.RenderEnv(view)
 	case "json":
+		// The JSON form is the whole project, every environment included, so
+		// it imports back layer for layer.
+		if env != "" {
+			return errors.New("a JSON export holds every environment; use --format env to export one")
+		}
 		rendered, err = appcore.RenderProjectJSON(bundle)
 		if err != nil {
 			return err
@@ -257,7 +396,7 @@ func cmdExport(app *appcore.App, args []string) error {
 	if err := os.WriteFile(abs, []byte(rendered), 0o600); err != nil {
 		return err
 	}
-	fmt.Printf("Exported %s (%s) to %s\n", resolvedName, selectedFormat, abs)
+	fmt.Printf("Exported %s (%s) to %s\n", projectLabel(resolvedName, env), selectedFormat, abs)
 	return nil
 }
 
@@ -270,18 +409,23 @@ func cmdRun(app *appcore.App, args []string) error {
 		}
 	}
 	if idx == -1 {
-		return errors.New("usage: veil run [-p project] -- COMMAND")
+		return errors.New("usage: veil run [-p project] [-e env] -- COMMAND")
 	}
-	left := reorderFlags(args[:idx], map[string]bool{"-p": true})
+	left := reorderFlags(args[:idx], withEnvFlags(map[string]bool{"-p": true}))
 	fs := flag.NewFlagSet("run", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
 	if err := fs.Parse(left); err != nil {
 		return err
 	}
 	commandArgs := args[idx+1:]
 	if len(commandArgs) == 0 {
-		return errors.New("usage: veil run [-p project] -- COMMAND")
+		return errors.New("usage: veil run [-p project] [-e env] -- COMMAND")
+	}
+	env, err := appcore.NormalizeEnv(*envFlag)
+	if err != nil {
+		return err
 	}
 	project, path, err := app.ResolveProject(*projectFlag)
 	if err != nil {
@@ -291,32 +435,269 @@ func cmdRun(app *appcore.App, args []string) error {
 	if err != nil {
 		return err
 	}
+	if err := app.CheckEnv(bundle, env); err != nil {
+		return err
+	}
+	resolved, err := app.ExpandedSecrets(bundle, env)
+	if err != nil {
+		return err
+	}
 	cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Env = os.Environ()
-	for _, secret := range bundle.Secrets {
+	for _, secret := range resolved {
 		cmd.Env = append(cmd.Env, secret.Key+"="+secret.Value)
 	}
 	return cmd.Run()
 }
 
 func cmdSync(app *appcore.App, args []string) error {
-	args = reorderFlags(args, map[string]bool{"--token": true})
+	args = reorderFlags(args, map[string]bool{"--token": true, "--allow-unsigned": false, "--dry-run": false})
 	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	token := fs.String("token", "", "GitHub token override")
+	allowUnsigned := fs.Bool("allow-unsigned", false, "accept unsigned bundles written by older versions")
+	dryRun := fs.Bool("dry-run", false, "print the sync plan without writing anything")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
-	if err := app.Sync(*token); err != nil {
+	report, err := app.Sync(appcore.SyncOptions{Token: *token, AllowUnsigned: *allowUnsigned, DryRun: *dryRun})
+	var incomplete *appcore.SyncIncompleteError
+	if err != nil && !errors.As(err, &incomplete) {
 		return err
 	}
+	for _, failure := range report.Failed {
+		fmt.Printf("Failed %s: %v\n", failure.Project, failure.Err)
+	}
+	for _, warning := range report.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	if *dryRun {
+		printSyncPlan(report)
+		return syncIncomplete(incomplete)
+	}
+	for _, project := range report.Skipped {
+		fmt.Printf("Skipped %s (no readable local or remote copy)\n", project)
+	}
+	for _, blob := range report.Quarantined {
+		fmt.Printf("Quarantined remote %s: %s (saved to %s)\n", blob.Project, blob.Reason, blob.Path)
+	}
+	for _, key := range report.NewPending {
+		fmt.Printf("New machine key awaiting approval: %s (run `veil machines approve` on a trusted machine)\n", key)
+	}
+	if len(report.Conflicts) > 0 {
+		fmt.Printf("%d conflicts (same key changed on both sides):\n", len(report.Conflicts))
+		for _, conflict := range report.Conflicts {
+			fmt.Printf("  %s/%s: kept %s\n", conflict.Project, appcore.EnvKey(conflict.Env, conflict.Key), conflict.Kept)
+		}
+	}
+	if incomplete != nil {
+		return syncIncomplete(incomplete)
+	}
 	fmt.Println("Sync complete")
 	return nil
 }
 
+func syncIncomplete(err *appcore.SyncIncompleteError) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%d project(s) were not synced; the rest are up to date (fix the errors above and run `veil sync` again)", len(err.Failed))
+}
+
+func printSyncPlan(report *appcore.SyncReport) {
+	for _, project := range report.Skipped {
+		fmt.Printf("Would skip %s (no readable local or remote copy)\n", project)
+	}
+	for _, blob := range report.Quarantined {
+		fmt.Printf("Would quarantine remote %s: %s\n", blob.Project, blob.Reason)
+	}
+	for _, key := range report.NewPending {
+		fmt.Printf("New machine key on the remote: %s\n", key)
+	}
+	if len(report.Plan) == 0 {
+		fmt.Println("Nothing to sync (dry run)")
+		return
+	}
+	project := ""
+	for _, change := range report.Plan {
+		if change.Project != project {
+			project = change.Project
+			fmt.Println(project)
+		}
+		line := fmt.Sprintf("  %-9s %s", change.Action, appcore.EnvKey(change.Env, change.Key))
+		if change.Value != "" {
+			line += "=" + change.Value
+		}
+		if change.Detail != "" {
+			line += " (" + change.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("%d change(s) planned (dry run, nothing written)\n", len(report.Plan))
+}
+
+func cmdHistory(app *appcore.App, args []string) error {
+	args = reorderFlags(args, withEnvFlags(map[string]bool{"--remote": false, "--token": true, "-p": true}))
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	remote := fs.Bool("remote", false, "list revisions of the linked gist")
+	token := fs.String("token", "", "GitHub token override")
+	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*remote {
+		if fs.NArg() != 1 {
+			return errors.New("usage: veil history KEY [-p project] [-e env] | veil history --remote")
+		}
+		return printSecretHistory(app, fs.Arg(0), *projectFlag, *envFlag)
+	}
+	revisions, err := app.RemoteHistory(*token)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		fmt.Println("No revisions")
+		return nil
+	}
+	fmt.Println("REVISION\tCOMMITTED\tAUTHOR\tCHANGES")
+	for _, revision := range revisions {
+		fmt.Printf("%s\t%s\t%s\t+%d -%d\n", revision.Version, orDash(revision.CommittedAt), orDash(revision.Author), revision.Additions, revision.Deletions)
+	}
+	return nil
+}
+
+func printSecretHistory(app *appcore.App, key, projectName, envName string) error {
+	env, err := appcore.NormalizeEnv(envName)
+	if err != nil {
+		return err
+	}
+	project, path, err := app.ResolveProject(projectName)
+	if err != nil {
+		return err
+	}
+	bundle, err := app.LoadProject(project, path)
+	if err != nil {
+		return err
+	}
+	secret, ok := appcore.GetSecret(bundle, env, key)
+	if !ok {
+		return fmt.Errorf("key %q not found in project %q", key, projectLabel(project, env))
+	}
+	fmt.Println("VERSION\tUPDATED\tMACHINE\tVALUE")
+	for i, version := range appcore.SecretVersions(secret) {
+		value := appcore.MaskValue(version.Value)
+		if i == 0 {
+			value += " (current)"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\n", i, orDash(version.UpdatedAt), orDash(app.MachineLabel(version.UpdatedBy)), value)
+	}
+	return nil
+}
+
+func cmdRollback(app *appcore.App, args []string) error {
+	args = reorderFlags(args, withEnvFlags(map[string]bool{"--revision": true, "--to": true, "-p": true, "--token": true, "--allow-unsigned": false, "-y": false}))
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	revision := fs.String("revision", "", "gist revision sha (a unique prefix is enough)")
+	to := fs.Int("to", -1, "restore KEY to this version from `veil history KEY`")
+	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
+	token := fs.String("token", "", "GitHub token override")
+	allowUnsigned := fs.Bool("allow-unsigned", false, "accept an unsigned revision written by an older version")
+	yes := fs.Bool("y", false, "skip confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 1 && *to >= 0 && strings.TrimSpace(*revision) == "" {
+		return rollbackSecret(app, fs.Arg(0), *to, *projectFlag, *envFlag, *yes)
+	}
+	if strings.TrimSpace(*revision) == "" || fs.NArg() > 0 {
+		return errors.New("usage: veil rollback --revision SHA [-p project] [-e env] [-y] | veil rollback KEY --to N [-p project] [-e env] [-y]")
+	}
+	project, path, err := app.ResolveProject(*projectFlag)
+	if err != nil {
+		return err
+	}
+	plan, err := app.PlanRollback(appcore.RollbackOptions{
+		Revision:      *revision,
+		Project:       project,
+		Env:           *envFlag,
+		Path:          path,
+		Token:         *token,
+		AllowUnsigned: *allowUnsigned,
+	})
+	if err != nil {
+		return err
+	}
+	short := plan.Revision
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Printf("%s already matches revision %s\n", plan.Project, short)
+		return nil
+	}
+	fmt.Printf("Rolling %s back to revision %s:\n", plan.Project, short)
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case appcore.ChangeDelete:
+			fmt.Printf("  - %s\n", appcore.EnvKey(change.Env, change.Key))
+		default:
+			fmt.Printf("  ~ %s=%s\n", appcore.EnvKey(change.Env, change.Key), change.Value)
+		}
+	}
+	if !*yes {
+		fmt.Printf("Restore %d key(s) locally? [y/N]: ", len(plan.Changes))
+		in := bufio.NewScanner(os.Stdin)
+		if !in.Scan() || strings.ToLower(strings.TrimSpace(in.Text())) != "y" {
+			fmt.Println("Cancelled")
+			return nil
+		}
+	}
+	if err := app.ApplyRollback(plan); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from revision %s (run `veil sync` to push it)\n", plan.Project, short)
+	return nil
+}
+
+func rollbackSecret(app *appcore.App, key string, version int, projectName, envName string, yes bool) error {
+	env, err := appcore.NormalizeEnv(envName)
+	if err != nil {
+		return err
+	}
+	project, path, err := app.ResolveProject(projectName)
+	if err != nil {
+		return err
+	}
+	bundle, err := app.LoadProject(project, path)
+	if err != nil {
+		return err
+	}
+	restored, err := appcore.RestoreVersion(bundle, env, key, version)
+	if err != nil {
+		return err
+	}
+	if !yes {
+		fmt.Printf("Restore %s in %s to version %d (%s from %s)? [y/N]: ", key, projectLabel(project, env), version, appcore.MaskValue(restored.Value), orDash(restored.UpdatedAt))
+		in := bufio.NewScanner(os.Stdin)
+		if !in.Scan() || strings.ToLower(strings.TrimSpace(in.Text())) != "y" {
+			fmt.Println("Cancelled")
+			return nil
+		}
+	}
+	if err := app.SaveProject(bundle); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s in %s to version %d\n", key, projectLabel(project, env), version)
+	return nil
+}
+
 func cmdList(app *appcore.App, args []string) error {
 	fs := flag.NewFlagSet("list", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
@@ -339,13 +720,18 @@ func cmdList(app *appcore.App, args []string) error {
 }
 
 func cmdLS(app *appcore.App, args []string) error {
-	args = reorderFlags(args, map[string]bool{"-p": true})
+	args = reorderFlags(args, withEnvFlags(map[string]bool{"-p": true}))
 	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	env, err := appcore.NormalizeEnv(*envFlag)
+	if err != nil {
+		return err
+	}
 	remaining := fs.Args()
 	project := *projectFlag
 	if len(remaining) > 0 {
@@ -359,11 +745,23 @@ func cmdLS(app *appcore.App, args []string) error {
 	if err != nil {
 		return err
 	}
-	if len(bundle.Secrets) == 0 {
-		fmt.Printf("No secrets in %s\n", resolvedName)
+	if err := app.CheckEnv(bundle, env); err != nil {
+		return err
+	}
+	if envs := appcore.Environments(bundle); len(envs) > 0 {
+		fmt.Printf("Environments: %s (showing %s)\n", strings.Join(envs, ", "), appcore.EnvLabel(env))
+	}
+	if len(bundle.Includes) > 0 {
+		fmt.Printf("Includes: %s\n", strings.Join(bundle.Includes, ", "))
+	}
+	sorted, err := app.ResolveSecrets(bundle, env)
+	if err != nil {
+		return err
+	}
+	if len(sorted) == 0 {
+		fmt.Printf("No secrets in %s\n", projectLabel(resolvedName, env))
 		return nil
 	}
-	sorted := append([]appcore.Secret(nil), bundle.Secrets...)
 	sort.Slice(sorted, func(i, j int) bool {
 		if sorted[i].Group == sorted[j].Group {
 			return sorted[i].Key < sorted[j].Key
@@ -376,23 +774,55 @@ func cmdLS(app *appcore.App, args []string) error {
 			currentGroup = secret.Group
 			fmt.Printf("[%s]\n", currentGroup)
 		}
-		fmt.Printf("  %s=%s\n", secret.Key, appcore.MaskValue(secret.Value))
+		line := fmt.Sprintf("  %s=%s", secret.Key, appcore.MaskValue(secret.Value))
+		if layer := appcore.LayerName(resolvedName, env); secret.Layer != layer {
+			line += "  (from " + secret.Layer + ")"
+		}
+		fmt.Println(line)
+		if details := secretDetails(secret.Secret); details != "" {
+			fmt.Println("      " + details)
+		}
 	}
 	return nil
 }
 
+func secretDetails(secret appcore.Secret) string {
+	var details []string
+	if secret.Description != "" {
+		details = append(details, secret.Description)
+	}
+	if len(secret.Tags) > 0 {
+		details = append(details, "tags: "+strings.Join(secret.Tags, ", "))
+	}
+	if secret.Source != "" {
+		details = append(details, "source: "+secret.Source)
+	}
+	if expiry := appcore.ExpiryLabel(secret.ExpiresAt, time.Now()); expiry != "" {
+		details = append(details, expiry)
+	}
+	if secret.Expand {
+		details = append(details, "expands references")
+	}
+	return strings.Join(details, " · ")
+}
+
 func cmdRM(app *appcore.App, args []string) error {
-	args = reorderFlags(args, map[string]bool{"-p": true, "-y": false})
+	args = reorderFlags(args, withEnvFlags(map[string]bool{"-p": true, "-y": false}))
 	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	projectFlag := fs.String("p", "", "project override")
+	envFlag := addEnvFlag(fs)
 	yes := fs.Bool("y", false, "skip confirmation")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	remaining := fs.Args()
 	if len(remaining) < 1 {
-		return errors.New("usage: veil rm KEY [-p project] [-y]")
+		return errors.New("usage: veil rm KEY [-p project] [-e env] [-y]")
+	}
+	env, err := appcore.NormalizeEnv(*envFlag)
+	if err != nil {
+		return err
 	}
 	key := remaining[0]
 	project, path, err := app.ResolveProject(*projectFlag)
@@ -403,46 +833,554 @@ func cmdRM(app *appcore.App, args []string) error {
 	if err != nil {
 		return err
 	}
-	if _, exists := appcore.GetSecret(bundle, key); !exists {
-		return fmt.Errorf("key %q not found in %q", key, project)
+	if _, exists := appcore.GetSecret(bundle, env, key); !exists {
+		if _, inherited := appcore.GetSecret(bundle, "", key); inherited && env != "" {
+			return fmt.Errorf("key %q is inherited from the base layer of %q (remove it with `-e base` or override it with `veil set -e %s`)", key, project, env)
+		}
+		return fmt.Errorf("key %q not found in %q", key, projectLabel(project, env))
 	}
 	if !*yes {
-		fmt.Printf("Delete %s from %s? [y/N]: ", key, project)
+		fmt.Printf("Delete %s from %s? [y/N]: ", key, projectLabel(project, env))
 		in := bufio.NewScanner(os.Stdin)
 		if !in.Scan() || strings.ToLower(strings.TrimSpace(in.Text())) != "y" {
 			fmt.Println("Cancelled")
 			return nil
 		}
 	}
-	if !appcore.RemoveSecret(bundle, key) {
-		return fmt.Errorf("key %q not found in %q", key, project)
+	if !appcore.RemoveSecret(bundle, env, key) {
+		return fmt.Errorf("key %q not found in %q", key, projectLabel(project, env))
 	}
 	if err := app.SaveProject(bundle); err != nil {
 		return err
 	}
-	fmt.Printf("Deleted %s from %s\n", key, project)
+	fmt.Printf("Deleted %s from %s\n", key, projectLabel(project, env))
+	return nil
+}
+
+func cmdInclude(app *appcore.App, args []string) error {
+	action := "ls"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	args = reorderFlags(args, map[string]bool{"-p": true})
+	fs := flag.NewFlagSet("include", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	projectFlag := fs.String("p", "", "project override")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	project, path, err := app.ResolveProject(*projectFlag)
+	if err != nil {
+		return err
+	}
+	bundle, err := app.LoadProject(project, path)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "ls":
+		if len(bundle.Includes) == 0 {
+			fmt.Printf("%s includes no shared bundles\n", project)
+			return nil
+		}
+		for _, name := range bundle.Includes {
+			fmt.Println(name)
+		}
+		return nil
+	case "add":
+		if fs.NArg() < 1 {
+			return errors.New("usage: veil include add BUNDLE... [-p project]")
+		}
+		for _, name := range fs.Args() {
+			if err := app.AddInclude(bundle, name); err != nil {
+				return err
+			}
+		}
+	case "rm":
+		if fs.NArg() < 1 {
+			return errors.New("usage: veil include rm BUNDLE... [-p project]")
+		}
+		for _, name := range fs.Args() {
+			if !appcore.RemoveInclude(bundle, name) {
+				return fmt.Errorf("%s does not include %q", project, name)
+			}
+		}
+	default:
+		return errors.New("usage: veil include [ls|add|rm] [BUNDLE...] [-p project]")
+	}
+	if err := app.SaveProject(bundle); err != nil {
+		return err
+	}
+	fmt.Printf("%s includes: %s\n", project, orDash(strings.Join(bundle.Includes, ", ")))
 	return nil
 }
 
 func cmdLink(app *appcore.App, args []string) error {
-	args = reorderFlags(args, map[string]bool{"--token": true, "--gist": true})
+	args = reorderFlags(args, map[string]bool{
+		"--token": true, "--gist": true, "--backend": true, "--path": true,
+		"--bucket": true, "--prefix": true, "--region": true, "--endpoint": true, "--access-key": true, "--secret-key-stdin": false,
+		"--url": true, "--user": true, "--password-stdin": false,
+	})
 	fs := flag.NewFlagSet("link", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
+	backend := fs.String("backend", "", "sync backend: gist, dir, git, s3 or webdav")
 	token := fs.String("token", "", "GitHub token")
 	gistID := fs.String("gist", "", "existing gist id")
+	path := fs.String("path", "", "directory or git checkout for the dir/git backends")
+	bucket := fs.String("bucket", "", "s3 bucket")
+	prefix := fs.String("prefix", "", "s3 key prefix (default veil/)")
+	region := fs.String("region", "", "s3 region (default us-east-1)")
+	endpoint := fs.String("endpoint", "", "s3-compatible endpoint URL, e.g. a MinIO server")
+	accessKey := fs.String("access-key", "", "s3 access key, saved to the keychain")
+	secretKeyStdin := fs.Bool("secret-key-stdin", false, "read the s3 secret key from stdin (default: $AWS_SECRET_ACCESS_KEY, else prompt); saved to the keychain with --access-key")
+	davURL := fs.String("url", "", "webdav collection URL, e.g. a Nextcloud folder")
+	user := fs.String("user", "", "webdav user, saved to the keychain")
+	passwordStdin := fs.Bool("password-stdin", false, "read the webdav password or app token from stdin (default: $VEIL_WEBDAV_PASSWORD, else prompt)")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	if strings.TrimSpace(*token) != "" {
 		_ = app.StoreGitHubToken(*token)
 	}
-	if err := app.Link(*token, *gistID); err != nil {
+	// Neither the WebDAV password nor the S3 secret key ever comes from an
+	// argument, where it would show up in the process list and shell history.
+	if *passwordStdin && *secretKeyStdin {
+		return errors.New("only one of --password-stdin and --secret-key-stdin can read stdin")
+	}
+	if *secretKeyStdin && *accessKey == "" {
+		return errors.New("--secret-key-stdin needs --access-key")
+	}
+	password := os.Getenv("VEIL_WEBDAV_PASSWORD")
+	if *passwordStdin {
+		line, err := readStdinLine("password")
+		if err != nil {
+			return err
+		}
+		password = line
+	}
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if *secretKeyStdin {
+		line, err := readStdinLine("secret key")
+		if err != nil {
+			return err
+		}
+		secretKey = line
+	}
+	opts := appcore.LinkOptions{
+		Backend:   *backend,
+		Token:     *token,
+		GistID:    *gistID,
+		Path:      *path,
+		URL:       *endpoint,
+		Bucket:    *bucket,
+		Prefix:    *prefix,
+		Region:    *region,
+		AccessKey: *accessKey,
+		SecretKey: secretKey,
+		User:      *user,
+		Password:  password,
+	}
+	if *davURL != "" {
+		opts.URL = *davURL
+	}
+	if err := app.Link(opts); err != nil {
 		return err
 	}
-	fmt.Printf("Linked gist %s\n", app.LinkedGistID())
+	fmt.Printf("Linked %s\n", app.LinkedRemote())
+	return printPendingHint(app)
+}
+
+// readStdinLine reads one secret from stdin, for `--*-stdin` flags.
+func readStdinLine(what string) (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read %s from stdin: %w", what, err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func cmdMachines(app *appcore.App, args []string) error {
+	sub := "list"
+	if len(args) > 0 {
+		sub = args[0]
+		args = args[1:]
+	}
+	switch sub {
+	case "list", "ls":
+		machines, err := app.Machines()
+		if err != nil {
+			return err
+		}
+		fmt.Println("NAME\tID\tSTATUS\tADDED\tLAST SEEN\tPUBLIC KEY")
+		for _, machine := range machines {
+			name := machine.Name
+			if machine.Current {
+				name += " (this machine)"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", name, orDash(machine.ID), machine.Status, orDash(machine.AddedAt), orDash(machine.LastSeenAt), machine.PublicKey)
+		}
+		signers, err := app.PendingSigners()
+		if err != nil {
+			return err
+		}
+		for _, signer := range signers {
+			fmt.Printf("pending signing key %s of machine %s (seen %s)\n", signer.PublicKey, signer.MachineID, signer.FirstSeenAt)
+		}
+		return nil
+	case "rename":
+		if len(args) < 2 {
+			return errors.New("usage: veil machines rename MACHINE NEW_NAME")
+		}
+		old, err := app.RenameMachine(args[0], strings.Join(args[1:], " "))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Renamed %s to %s\n", old, strings.Join(args[1:], " "))
+		return nil
+	case "revoke":
+		args = reorderFlags(args, map[string]bool{"--token": true, "-y": false})
+		fs := flag.NewFlagSet("machines revoke", flag.ContinueOnError)
+		fs.SetOutput(os.Stdout)
+		token := fs.String("token", "", "GitHub token override")
+		yes := fs.Bool("y", false, "skip confirmation")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() < 1 {
+			return errors.New("usage: veil machines revoke MACHINE [-y]")
+		}
+		if !*yes {
+			fmt.Printf("Revoke %s and re-encrypt every project without it? [y/N]: ", fs.Arg(0))
+			in := bufio.NewScanner(os.Stdin)
+			if !in.Scan() || strings.ToLower(strings.TrimSpace(in.Text())) != "y" {
+				fmt.Println("Cancelled")
+				return nil
+			}
+		}
+		if _, err := app.RevokeMachine(fs.Arg(0), appcore.SyncOptions{Token: *token}); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked %s and re-encrypted all projects\n", fs.Arg(0))
+		fmt.Println("Secrets it could already read should be rotated at their source")
+		return nil
+	case "approve":
+		args = reorderFlags(args, map[string]bool{"--all": false})
+		fs := flag.NewFlagSet("machines approve", flag.ContinueOnError)
+		fs.SetOutput(os.Stdout)
+		all := fs.Bool("all", false, "approve every pending key")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		queries := fs.Args()
+		if *all {
+			pending, err := app.PendingRecipients()
+			if err != nil {
+				return err
+			}
+			signers, err := app.PendingSigners()
+			if err != nil {
+				return err
+			}
+			if len(pending) == 0 && len(signers) == 0 {
+				fmt.Println("No pending machine keys")
+				return nil
+			}
+			queries = queries[:0]
+			for _, p := range pending {
+				queries = append(queries, p.PublicKey)
+			}
+			for _, p := range signers {
+				queries = append(queries, p.PublicKey)
+			}
+		}
+		if len(queries) == 0 {
+			return errors.New("usage: veil machines approve KEY|--all")
+		}
+		for _, query := range queries {
+			key, err := app.ApproveMachine(query)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Approved %s\n", key)
+		}
+		fmt.Println("Run `veil sync` to re-encrypt projects for the approved machines")
+		return nil
+	case "deny":
+		if len(args) < 1 {
+			return errors.New("usage: veil machines deny KEY")
+		}
+		key, err := app.DenyMachine(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Denied %s (it will be removed from the remote on next sync)\n", key)
+		return nil
+	default:
+		return fmt.Errorf("unknown machines command %q (use list, approve, deny, rename or revoke)", sub)
+	}
+}
+
+func cmdRotateKey(app *appcore.App, args []string) error {
+	args = reorderFlags(args, map[string]bool{"--token": true, "-y": false})
+	fs := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	token := fs.String("token", "", "GitHub token override")
+	yes := fs.Bool("y", false, "skip confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*yes {
+		fmt.Print("Replace this machine's age key and re-encrypt every project? [y/N]: ")
+		in := bufio.NewScanner(os.Stdin)
+		if !in.Scan() || strings.ToLower(strings.TrimSpace(in.Text())) != "y" {
+			fmt.Println("Cancelled")
+			return nil
+		}
+	}
+	newKey, err := app.RotateKey(appcore.SyncOptions{Token: *token})
+	if err != nil {
+		return err
+	}
+	config, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rotated key for %s\n", config.Machine.Name)
+	fmt.Printf("New public key: %s\n", newKey)
+	if config.Remote.Linked() {
+		fmt.Printf("Other machines must approve it with `veil machines approve %s`\n", config.Machine.ID)
+	}
+	fmt.Println("Old recovery sheets no longer work; run `veil recovery export` again")
+	return nil
+}
+
+func cmdConfig(app *appcore.App, args []string) error {
+	if len(args) > 0 && args[0] == "github" {
+		return cmdConfigGitHub(app, args[1:])
+	}
+	if len(args) == 0 || args[0] != "key-storage" {
+		return errors.New("usage: veil config key-storage [file|keychain|passphrase] | veil config github [flags]")
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		fmt.Println(cfg.KeyStorage)
+		return nil
+	}
+	previous := cfg.KeyStorage
+	if err := app.MigrateKeyStorage(args[1]); err != nil {
+		return err
+	}
+	if previous == cfg.KeyStorage {
+		fmt.Printf("Key storage is already %s\n", previous)
+		return nil
+	}
+	fmt.Printf("Moved identity from %s to %s storage\n", previous, cfg.KeyStorage)
+	return nil
+}
+
+func cmdConfigGitHub(app *appcore.App, args []string) error {
+	args = reorderFlags(args, map[string]bool{"--api-url": true, "--oauth-url": true, "--proxy": true, "--timeout": true, "--reset": false})
+	fs := flag.NewFlagSet("config github", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	apiURL := fs.String("api-url", "", "GitHub API base url (GitHub Enterprise: https://HOST/api/v3)")
+	oauthURL := fs.String("oauth-url", "", "GitHub web url used for device flow login")
+	proxy := fs.String("proxy", "", "HTTP proxy for GitHub calls")
+	timeout := fs.String("timeout", "", "timeout per GitHub request, e.g. 30s")
+	reset := fs.Bool("reset", false, "go back to github.com defaults")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	settings := cfg.GitHub
+	changed := *reset
+	if *reset {
+		settings = appcore.GitHubConfig{}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "api-url":
+			settings.APIURL = *apiURL
+		case "oauth-url":
+			settings.OAuthURL = *oauthURL
+		case "proxy":
+			settings.Proxy = *proxy
+		case "timeout":
+			settings.Timeout = *timeout
+		default:
+			return
+		}
+		changed = true
+	})
+	if changed {
+		if err := app.SetGitHubConfig(settings); err != nil {
+			return err
+		}
+		settings = cfg.GitHub
+	}
+	fmt.Printf("API URL:   %s\n", orDash(settings.APIURL))
+	fmt.Printf("OAuth URL: %s\n", orDash(settings.OAuthURL))
+	fmt.Printf("Proxy:     %s\n", orDash(settings.Proxy))
+	fmt.Printf("Timeout:   %s\n", orDash(settings.Timeout))
+	return nil
+}
+
+func cmdRecovery(app *appcore.App, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: veil recovery export|restore")
+	}
+	switch args[0] {
+	case "export":
+		args = reorderFlags(args[1:], map[string]bool{"--qr": false, "-o": true})
+		fs := flag.NewFlagSet("recovery export", flag.ContinueOnError)
+		fs.SetOutput(os.Stdout)
+		asQR := fs.Bool("qr", false, "render the sheet as a QR code")
+		output := fs.String("o", "", "write to file (.png for a QR image)")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		sheet, err := app.ExportRecovery()
+		if err != nil {
+			return err
+		}
+		if *asQR {
+			qr, err := qrcode.New(sheet.Payload(), qrcode.Medium)
+			if err != nil {
+				return fmt.Errorf("render recovery qr: %w", err)
+			}
+			if strings.HasSuffix(strings.ToLower(*output), ".png") {
+				if err := qr.WriteFile(512, *output); err != nil {
+					return fmt.Errorf("write recovery qr: %w", err)
+				}
+				fmt.Printf("Wrote recovery QR to %s\n", *output)
+				return nil
+			}
+			if *output != "" {
+				return errors.New("QR output file must end in .png")
+			}
+			fmt.Println(qr.ToSmallString(false))
+			fmt.Fprintln(os.Stderr, "Scan and save the text; restore with `veil recovery restore -`")
+			return nil
+		}
+		if *output != "" {
+			if err := os.WriteFile(*output, []byte(sheet.Text()), 0o600); err != nil {
+				return fmt.Errorf("write recovery sheet: %w", err)
+			}
+			fmt.Printf("Wrote recovery sheet to %s (print it, then delete the file)\n", *output)
+			return nil
+		}
+		fmt.Print(sheet.Text())
+		return nil
+	case "restore":
+		args = reorderFlags(args[1:], map[string]bool{"--key-storage": true})
+		fs := flag.NewFlagSet("recovery restore", flag.ContinueOnError)
+		fs.SetOutput(os.Stdout)
+		keyStorage := fs.String("key-storage", "", "key storage backend: file, keychain or passphrase")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() < 1 {
+			return errors.New("usage: veil recovery restore FILE|- [--key-storage kind]")
+		}
+		var raw []byte
+		var err error
+		if fs.Arg(0) == "-" {
+			raw, err = io.ReadAll(os.Stdin)
+		} else {
+			raw, err = os.ReadFile(fs.Arg(0))
+		}
+		if err != nil {
+			return err
+		}
+		sheet, err := appcore.ParseRecoverySheet(string(raw))
+		if err != nil {
+			return err
+		}
+		if err := app.RestoreRecovery(sheet, *keyStorage); err != nil {
+			return err
+		}
+		fmt.Printf("Restored machine %s (%s)\n", sheet.MachineName, sheet.MachineID)
+		if app.LinkedRemote() == "" && sheet.Remote != "" {
+			kind, location, _ := strings.Cut(sheet.Remote, " ")
+			switch kind {
+			case "gist":
+				fmt.Printf("Pull your secrets with `veil link --gist %s`\n", location)
+			case "dir", "git":
+				fmt.Printf("Pull your secrets with `veil link --backend %s --path %s`\n", kind, location)
+			case "webdav":
+				fmt.Printf("Pull your secrets with `veil link --backend webdav --url %s`\n", location)
+			default:
+				fmt.Printf("Reconnect to %s with `veil link --backend %s` to pull your secrets\n", location, kind)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown recovery command %q (use export or restore)", args[0])
+	}
+}
+
+func cmdDoctor(app *appcore.App) error {
+	failed := 0
+	for _, check := range app.Doctor() {
+		mark := "ok"
+		if !check.OK {
+			mark = "FAIL"
+			failed++
+		}
+		fmt.Printf("[%s] %s: %s\n", mark, check.Name, check.Detail)
+		if check.Hint != "" {
+			fmt.Printf("       %s\n", check.Hint)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+func cmdStatus(app *appcore.App) error {
+	report, err := app.Status()
+	if err != nil {
+		return err
+	}
+	if report.Remote == "" {
+		fmt.Println("Remote: not linked")
+	} else {
+		fmt.Printf("Remote: %s (last sync %s)\n", report.Remote, orDash(report.LastSyncedAt))
+	}
+	if len(report.Projects) == 0 {
+		fmt.Println("No projects")
+		return nil
+	}
+	fmt.Println("PROJECT\tSTATE\tCHANGED\tPUSHED")
+	for _, project := range report.Projects {
+		state := "pushed"
+		switch {
+		case report.Remote == "":
+			state = "local only"
+		case project.Pending:
+			state = "pending push"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", project.Project, state, orDash(project.ChangedAt), orDash(project.PushedAt))
+	}
+	if pending := report.PendingCount(); pending > 0 {
+		fmt.Printf("%d project(s) pending push (run `veil sync`)\n", pending)
+	}
 	return nil
 }
 
+func orDash(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "-"
+	}
+	return value
+}
+
 func printHelp() {
 	fmt.Println("Veil - TUI-first encrypted secret manager")
 	fmt.Println()
@@ -452,20 +1390,52 @@ func printHelp() {
 	fmt.Println()
 	fmt.Println("Commands:")
 	fmt.Println("  init                First-time setup wizard")
-	fmt.Println("  set KEY VALUE       Add or update a secret")
-	fmt.Println("  get KEY             Retrieve a secret value")
+	fmt.Println("  set KEY VALUE       Add or update a secret (--desc, --tags, --source, --expires)")
+	fmt.Println("  get KEY [--resolve] Retrieve a secret value")
 	fmt.Println("  import FILE|-       Batch import from .env file")
 	fmt.Println("  export PROJECT      Export project secrets")
 	fmt.Println("  run -- COMMAND      Inject secrets into subprocess")
 	fmt.Println("  sync                Push/pull encrypted secrets")
+	fmt.Println("  status              Show projects with unpushed changes")
+	fmt.Println("  history KEY         List previous values of a secret")
+	fmt.Println("  history --remote    List revisions of the linked gist")
+	fmt.Println("  rollback            Restore a project from an older gist revision")
+	fmt.Println("  rollback KEY --to N Restore a secret to an earlier version")
 	fmt.Println("  list                Show projects with secret counts")
 	fmt.Println("  ls PROJECT          Show keys in a project")
 	fmt.Println("  rm KEY              Delete a secret")
-	fmt.Println("  link                Connect to GitHub gist")
+	fmt.Println("  include             List, add or remove shared bundles a project reads through")
+	fmt.Println("  link                Connect to a gist, directory, git repo, S3 or WebDAV remote")
+	fmt.Println("  machines            List, approve, rename or revoke machines")
+	fmt.Println("  rotate-key          Replace this machine's age key")
+	fmt.Println("  config key-storage  Show or change where the age key is stored")
+	fmt.Println("  config github       Set the GitHub API/OAuth url, proxy and timeout")
+	fmt.Println("  doctor              Check config, identity and key storage")
+	fmt.Println("  recovery            Export or restore a paper recovery sheet")
 	fmt.Println()
 	fmt.Println("Project detection:")
 	fmt.Println("  Defaults to current directory and known markers")
 	fmt.Println("  Use -p <project> to override")
+	fmt.Println("  Use -e <env> to read or write an environment layered over the base")
+}
+
+func addEnvFlag(fs *flag.FlagSet) *string {
+	env := fs.String("e", "", "environment (default: the base layer)")
+	fs.StringVar(env, "env", "", "environment (default: the base layer)")
+	return env
+}
+
+func withEnvFlags(known map[string]bool) map[string]bool {
+	known["-e"] = true
+	known["--env"] = true
+	return known
+}
+
+func projectLabel(project, env string) string {
+	if env == "" {
+		return project
+	}
+	return project + " (" + env + ")"
 }
 
 func reorderFlags(args []string, known map[string]bool) []string {
@@ -6,23 +6,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
 	"sort"
 	"strings"
 
 	"filippo.io/age"
-	"github.com/zalando/go-keyring"
 )
 
 type App struct {
 	HomeDir     string
 	StoreDir    string
+	BaseDir     string
 	ConfigPath  string
 	config      Config
 	configReady bool
 	identity    *age.X25519Identity
+	passphrase  string
+	noPrompt    bool
+	httpClient  *http.Client
 }
 
 func NewApp() (*App, error) {
@@ -38,6 +41,7 @@ func NewApp() (*App, error) {
 	return &App{
 		HomeDir:    home,
 		StoreDir:   filepath.Join(home, "store"),
+		BaseDir:    filepath.Join(home, "base"),
 		ConfigPath: filepath.Join(home, "config.json"),
 	}, nil
 }
@@ -95,6 +99,17 @@ func (a *App) LoadConfig() (*Config, error) {
 	if a.config.Prefs.ExportFormat == "" {
 		a.config.Prefs.ExportFormat = "env"
 	}
+	if legacy := a.config.LegacyGist; legacy != nil {
+		if a.config.Remote.Type == "" && legacy.ID != "" {
+			a.config.Remote = RemoteConfig{
+				Type:         remoteGist,
+				ID:           legacy.ID,
+				Owner:        legacy.Owner,
+				LastSyncedAt: legacy.LastSyncedAt,
+			}
+		}
+		a.config.LegacyGist = nil
+	}
 	a.configReady = true
 	return &a.config, nil
 }
@@ -110,7 +125,7 @@ func (a *App) SaveConfig() error {
 	if err != nil {
 		return fmt.Errorf("encode config: %w", err)
 	}
-	if err := os.WriteFile(a.ConfigPath, b, 0o600); err != nil {
+	if err := writeFileAtomic(a.ConfigPath, b); err != nil {
 		return fmt.Errorf("write config: %w", err)
 	}
 	return nil
@@ -133,10 +148,10 @@ func randomID() (string, error) {
 
 func (a *App) Init(keyStorage string, machineName string) error {
 	if keyStorage == "" {
-		keyStorage = "file"
+		keyStorage = keyStorageFile
 	}
-	if keyStorage != "file" && keyStorage != "keychain" {
-		return fmt.Errorf("invalid key storage %q (use file or keychain)", keyStorage)
+	if !validKeyStorage(keyStorage) {
+		return fmt.Errorf("invalid key storage %q (use %s)", keyStorage, strings.Join(keyStorageKinds, ", "))
 	}
 	if _, err := a.LoadConfig(); err != nil {
 		return err
@@ -161,10 +176,11 @@ func (a *App) Init(keyStorage string, machineName string) error {
 		return fmt.Errorf("generate machine id: %w", err)
 	}
 	a.config.Machine = MachineConfig{
-		ID:        machineID,
-		Name:      machineName,
-		PublicKey: id.Recipient().String(),
-		AddedAt:   nowRFC3339(),
+		ID:         machineID,
+		Name:       machineName,
+		PublicKey:  id.Recipient().String(),
+		SigningKey: signingPublicKey(id),
+		AddedAt:    nowRFC3339(),
 	}
 	a.config.KeyStorage = keyStorage
 	a.identity = id
@@ -176,26 +192,15 @@ func (a *App) Init(keyStorage string, machineName string) error {
 }
 
 func (a *App) saveIdentity(id *age.X25519Identity) error {
-	if a.config.KeyStorage == "keychain" {
-		user := "age_" + a.config.Machine.ID
-		if err := keyring.Set(serviceName, user, id.String()); err != nil {
-			return fmt.Errorf("save identity to keychain: %w", err)
-		}
-		a.config.KeyFile = ""
-		return nil
-	}
-	keyDir := filepath.Join(a.HomeDir, "keys")
-	if err := os.MkdirAll(keyDir, 0o700); err != nil {
-		return fmt.Errorf("create key directory: %w", err)
-	}
-	path := filepath.Join(keyDir, a.config.Machine.ID+".txt")
-	if err := os.WriteFile(path, []byte(id.String()+"\n"), 0o600); err != nil {
-		return fmt.Errorf("write identity file: %w", err)
+	store, err := a.keyStoreFor(a.config.KeyStorage)
+	if err != nil {
+		return err
 	}
-	if runtime.GOOS != "windows" {
-		_ = os.Chmod(path, 0o600)
+	keyFile, err := store.Save(id)
+	if err != nil {
+		return err
 	}
-	a.config.KeyFile = path
+	a.config.KeyFile = keyFile
 	return nil
 }
 
@@ -209,29 +214,12 @@ func (a *App) LoadIdentity() (*age.X25519Identity, error) {
 	if !a.IsInitialized() {
 		return nil, errors.New("veil is not initialized (run `veil init`)")
 	}
-	if a.config.KeyStorage == "keychain" {
-		secret, err := keyring.Get(serviceName, "age_"+a.config.Machine.ID)
-		if err == nil {
-			id, parseErr := age.ParseX25519Identity(strings.TrimSpace(secret))
-			if parseErr == nil {
-				a.identity = id
-				return id, nil
-			}
-		}
-	}
-	if a.config.KeyFile == "" {
-		return nil, errors.New("missing key file path")
-	}
-	b, err := os.ReadFile(a.config.KeyFile)
-	if err != nil {
-		return nil, fmt.Errorf("read identity file: %w", err)
-	}
-	id, err := age.ParseX25519Identity(strings.TrimSpace(string(b)))
+	id, err := a.readIdentity()
 	if err != nil {
-		return nil, fmt.Errorf("parse identity file: %w", err)
+		return nil, err
 	}
 	a.identity = id
-	return id, nil
+	return id, a.ensureSigningKey(id)
 }
 
 func (a *App) registerProject(name, path string) {
@@ -278,9 +266,9 @@ func (a *App) ExportFormatPreference() string {
 	return strings.TrimSpace(a.config.Prefs.ExportFormat)
 }
 
-func (a *App) LinkedGistID() string {
+func (a *App) LinkedRemote() string {
 	if _, err := a.LoadConfig(); err != nil {
 		return ""
 	}
-	return strings.TrimSpace(a.config.Gist.ID)
+	return a.config.Remote.String()
 }
@@ -83,8 +83,71 @@ func RenderEnv(bundle *ProjectBundle) string {
 	return b.String()
 }
 
+// ParseProjectJSON reads the output of `veil export --format json`. Fields
+// added after an export was written are simply absent, so older exports
+// still import, and each secret stays in the environment it was exported from.
+func ParseProjectJSON(content string) ([]Secret, error) {
+	var bundle ProjectBundle
+	if err := json.Unmarshal([]byte(content), &bundle); err != nil {
+		return nil, fmt.Errorf("parse project json: %w", err)
+	}
+	for i, secret := range bundle.Secrets {
+		if strings.TrimSpace(secret.Key) == "" {
+			return nil, fmt.Errorf("empty key in secret %d", i+1)
+		}
+		env, err := NormalizeEnv(secret.Env)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", secret.Key, err)
+		}
+		bundle.Secrets[i].Env = env
+	}
+	return bundle.Secrets, nil
+}
+
+// ImportedIncludes lists the bundles the project in a JSON export included; a
+// .env file has none.
+func ImportedIncludes(content string) []string {
+	if !IsProjectJSON(content) {
+		return nil
+	}
+	var bundle ProjectBundle
+	if err := json.Unmarshal([]byte(content), &bundle); err != nil {
+		return nil
+	}
+	return bundle.Includes
+}
+
+// IsProjectJSON tells a JSON export from a .env file.
+func IsProjectJSON(content string) bool {
+	return strings.HasPrefix(strings.TrimSpace(content), "{")
+}
+
+// ParseImport accepts either a .env file, whose keys go into env, or a JSON
+// export; only the JSON form carries environments, groups and metadata.
+func ParseImport(content, env string) ([]Secret, error) {
+	if IsProjectJSON(content) {
+		return ParseProjectJSON(content)
+	}
+	pairs, err := ParseEnvContent(content)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]Secret, 0, len(pairs))
+	for _, pair := range pairs {
+		secrets = append(secrets, Secret{Env: env, Key: pair.Key, Value: pair.Value})
+	}
+	return secrets, nil
+}
+
+// Exports carry current values only; previous versions stay in the store.
 func RenderProjectJSON(bundle *ProjectBundle) (string, error) {
-	b, err := json.MarshalIndent(bundle, "", "  ")
+	view := *bundle
+	view.Secrets = make([]Secret, 0, len(bundle.Secrets))
+	for _, secret := range bundle.Secrets {
+		secret.History = nil
+		view.Secrets = append(view.Secrets, secret)
+	}
+	b, err := json.MarshalIndent(view, "", "  ")
 	if err != nil {
 		return "", err
 	}
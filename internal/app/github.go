@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,7 +20,20 @@ import (
 	"github.com/zalando/go-keyring"
 )
 
-const recipientsFileName = "recipients.txt"
+const (
+	recipientsFileName   = "recipients.txt"
+	defaultGitHubAPI     = "https://api.github.com"
+	defaultGitHubOAuth   = "https://github.com"
+	defaultGitHubTimeout = 30 * time.Second
+)
+
+type githubClient struct {
+	http  *http.Client
+	api   string
+	oauth string
+	proxy string
+	token string
+}
 
 type gistFile struct {
 	Filename  string `json:"filename"`
@@ -32,15 +46,154 @@ type gistFile struct {
 }
 
 type gistResponse struct {
-	ID    string              `json:"id"`
-	Files map[string]gistFile `json:"files"`
-	Owner struct {
+	ID         string              `json:"id"`
+	UpdatedAt  string              `json:"updated_at"`
+	Files      map[string]gistFile `json:"files"`
+	Truncated  bool                `json:"truncated"`
+	GitPullURL string              `json:"git_pull_url"`
+	Owner      struct {
 		Login string `json:"login"`
 	} `json:"owner"`
+	History []struct {
+		Version string `json:"version"`
+	} `json:"history"`
+}
+
+// updated_at only has second resolution, so the history head is part of the
+// revision too; together they change on every write.
+func (g *gistResponse) revision() string {
+	version := ""
+	if len(g.History) > 0 {
+		version = g.History[0].Version
+	}
+	return version + "@" + g.UpdatedAt
+}
+
+func (c GitHubConfig) apiURL() string {
+	if strings.TrimSpace(c.APIURL) == "" {
+		return defaultGitHubAPI
+	}
+	return strings.TrimRight(strings.TrimSpace(c.APIURL), "/")
+}
+
+func (c GitHubConfig) oauthURL() string {
+	if strings.TrimSpace(c.OAuthURL) == "" {
+		return defaultGitHubOAuth
+	}
+	return strings.TrimRight(strings.TrimSpace(c.OAuthURL), "/")
+}
+
+// enterpriseHost is empty for github.com; otherwise it is the host gh and the
+// enterprise token variables are keyed by.
+func (c GitHubConfig) enterpriseHost() string {
+	parsed, err := url.Parse(c.oauthURL())
+	if err != nil || parsed.Host == "github.com" {
+		return ""
+	}
+	return parsed.Host
+}
+
+func (c GitHubConfig) timeout() (time.Duration, error) {
+	if strings.TrimSpace(c.Timeout) == "" {
+		return defaultGitHubTimeout, nil
+	}
+	timeout, err := time.ParseDuration(strings.TrimSpace(c.Timeout))
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid github timeout %q (use a duration like 30s)", c.Timeout)
+	}
+	return timeout, nil
+}
+
+func (c GitHubConfig) httpClient() (*http.Client, error) {
+	timeout, err := c.timeout()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy := strings.TrimSpace(c.Proxy); proxy != "" {
+		parsed, err := url.Parse(proxy)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid github proxy %q", c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(parsed)
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+func validGitHubURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return fmt.Errorf("invalid url %q (use http:// or https://)", raw)
+	}
+	return nil
+}
+
+func (a *App) SetGitHubConfig(settings GitHubConfig) error {
+	if _, err := a.LoadConfig(); err != nil {
+		return err
+	}
+	settings.APIURL = strings.TrimRight(strings.TrimSpace(settings.APIURL), "/")
+	settings.OAuthURL = strings.TrimRight(strings.TrimSpace(settings.OAuthURL), "/")
+	settings.Proxy = strings.TrimSpace(settings.Proxy)
+	settings.Timeout = strings.TrimSpace(settings.Timeout)
+	for _, raw := range []string{settings.APIURL, settings.OAuthURL} {
+		if err := validGitHubURL(raw); err != nil {
+			return err
+		}
+	}
+	if _, err := settings.httpClient(); err != nil {
+		return err
+	}
+	a.config.GitHub = settings
+	return a.SaveConfig()
+}
+
+// SetHTTPClient replaces the client used for every GitHub call, so tests can
+// point veil at a fake server; the configured proxy and timeout are ignored
+// but retries still apply.
+func (a *App) SetHTTPClient(client *http.Client) {
+	a.httpClient = client
+}
+
+func (a *App) github(token string) (*githubClient, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return nil, err
+	}
+	settings := a.config.GitHub
+	client := a.httpClient
+	if client == nil {
+		var err error
+		if client, err = settings.httpClient(); err != nil {
+			return nil, err
+		}
+	}
+	// The client timeout moves onto the retrying transport, where it bounds
+	// each attempt instead of the attempts and waits together.
+	retrying := *client
+	retrying.Transport = newRetryTransport(client.Transport, client.Timeout)
+	retrying.Timeout = 0
+	return &githubClient{
+		http:  &retrying,
+		api:   settings.apiURL(),
+		oauth: settings.oauthURL(),
+		proxy: settings.Proxy,
+		token: token,
+	}, nil
 }
 
 func (a *App) LoadGitHubToken() (string, error) {
-	for _, key := range []string{"GH_TOKEN", "GITHUB_TOKEN"} {
+	if _, err := a.LoadConfig(); err != nil {
+		return "", err
+	}
+	host := a.config.GitHub.enterpriseHost()
+	envKeys := []string{"GH_TOKEN", "GITHUB_TOKEN"}
+	if host != "" {
+		envKeys = []string{"GH_ENTERPRISE_TOKEN", "GITHUB_ENTERPRISE_TOKEN"}
+	}
+	for _, key := range envKeys {
 		if value := strings.TrimSpace(os.Getenv(key)); value != "" {
 			return value, nil
 		}
@@ -48,7 +201,11 @@ func (a *App) LoadGitHubToken() (string, error) {
 	if token, err := keyring.Get(serviceName, githubTokenUser); err == nil && strings.TrimSpace(token) != "" {
 		return strings.TrimSpace(token), nil
 	}
-	out, err := exec.Command("gh", "auth", "token").Output()
+	ghArgs := []string{"auth", "token"}
+	if host != "" {
+		ghArgs = append(ghArgs, "--hostname", host)
+	}
+	out, err := exec.Command("gh", ghArgs...).Output()
 	if err == nil {
 		token := strings.TrimSpace(string(out))
 		if token != "" {
@@ -57,7 +214,11 @@ func (a *App) LoadGitHubToken() (string, error) {
 	}
 	clientID := strings.TrimSpace(os.Getenv("VEIL_GITHUB_CLIENT_ID"))
 	if clientID != "" {
-		token, flowErr := githubDeviceFlow(clientID)
+		gh, err := a.github("")
+		if err != nil {
+			return "", err
+		}
+		token, flowErr := gh.deviceFlow(clientID)
 		if flowErr == nil {
 			_ = a.StoreGitHubToken(token)
 			return token, nil
@@ -77,17 +238,17 @@ func (a *App) StoreGitHubToken(token string) error {
 	return nil
 }
 
-func githubDeviceFlow(clientID string) (string, error) {
+func (c *githubClient) deviceFlow(clientID string) (string, error) {
 	form := url.Values{}
 	form.Set("client_id", clientID)
 	form.Set("scope", "gist read:user")
-	req, err := http.NewRequest(http.MethodPost, "https://github.com/login/device/code", strings.NewReader(form.Encode()))
+	req, err := http.NewRequest(http.MethodPost, c.oauth+"/login/device/code", strings.NewReader(form.Encode()))
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -129,10 +290,10 @@ func githubDeviceFlow(clientID string) (string, error) {
 		form.Set("client_id", clientID)
 		form.Set("device_code", code.DeviceCode)
 		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
-		pollReq, _ := http.NewRequest(http.MethodPost, "https://github.com/login/oauth/access_token", strings.NewReader(form.Encode()))
+		pollReq, _ := http.NewRequest(http.MethodPost, c.oauth+"/login/oauth/access_token", strings.NewReader(form.Encode()))
 		pollReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		pollReq.Header.Set("Accept", "application/json")
-		pollResp, err := http.DefaultClient.Do(pollReq)
+		pollResp, err := c.http.Do(pollReq)
 		if err != nil {
 			continue
 		}
@@ -159,24 +320,24 @@ func githubDeviceFlow(clientID string) (string, error) {
 	return "", errors.New("device flow timed out")
 }
 
-func githubRequest(token, method, endpoint string, body []byte) (*http.Response, error) {
-	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+func (c *githubClient) request(method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.api+path, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-	if token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	return http.DefaultClient.Do(req)
+	return c.http.Do(req)
 }
 
-func fetchRawContent(rawURL string) (string, error) {
-	resp, err := http.Get(rawURL)
+func (c *githubClient) fetchRaw(rawURL string) (string, error) {
+	resp, err := c.http.Get(rawURL)
 	if err != nil {
 		return "", err
 	}
@@ -191,8 +352,8 @@ func fetchRawContent(rawURL string) (string, error) {
 	return string(b), nil
 }
 
-func getGist(token, gistID string) (*gistResponse, error) {
-	resp, err := githubRequest(token, http.MethodGet, "https://api.github.com/gists/"+gistID, nil)
+func (c *githubClient) getGist(gistID string) (*gistResponse, error) {
+	resp, err := c.request(http.MethodGet, "/gists/"+gistID, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -208,7 +369,7 @@ func getGist(token, gistID string) (*gistResponse, error) {
 	return &gist, nil
 }
 
-func createGist(token string, files map[string]string) (*gistResponse, error) {
+func (c *githubClient) createGist(files map[string]string) (*gistResponse, error) {
 	requestFiles := map[string]map[string]string{}
 	for name, content := range files {
 		requestFiles[name] = map[string]string{"content": content}
@@ -219,7 +380,7 @@ func createGist(token string, files map[string]string) (*gistResponse, error) {
 		"files":       requestFiles,
 	}
 	b, _ := json.Marshal(payload)
-	resp, err := githubRequest(token, http.MethodPost, "https://api.github.com/gists", b)
+	resp, err := c.request(http.MethodPost, "/gists", b)
 	if err != nil {
 		return nil, err
 	}
@@ -235,180 +396,223 @@ func createGist(token string, files map[string]string) (*gistResponse, error) {
 	return &gist, nil
 }
 
-func updateGist(token, gistID string, files map[string]string) error {
-	requestFiles := map[string]map[string]string{}
+func (c *githubClient) getGistVersion(gistID, version string) (*gistResponse, error) {
+	return c.getGist(gistID + "/" + version)
+}
+
+func (c *githubClient) updateGist(gistID string, files map[string]string) (*gistResponse, error) {
+	requestFiles := map[string]any{}
 	for name, content := range files {
 		requestFiles[name] = map[string]string{"content": content}
 	}
+	return c.patchGist(gistID, requestFiles)
+}
+
+// patchGist sends one PATCH; a nil entry in files deletes that file.
+func (c *githubClient) patchGist(gistID string, requestFiles map[string]any) (*gistResponse, error) {
 	payload := map[string]any{"files": requestFiles}
 	b, _ := json.Marshal(payload)
-	resp, err := githubRequest(token, http.MethodPatch, "https://api.github.com/gists/"+gistID, b)
+	resp, err := c.request(http.MethodPatch, "/gists/"+gistID, b)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("github gist update failed: %s %s", resp.Status, strings.TrimSpace(string(body)))
+		return nil, fmt.Errorf("github gist update failed: %s %s", resp.Status, strings.TrimSpace(string(body)))
 	}
-	return nil
+	var gist gistResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gist); err != nil {
+		return nil, err
+	}
+	return &gist, nil
 }
 
-func (a *App) Link(token, gistID string) error {
-	if _, err := a.LoadConfig(); err != nil {
-		return err
-	}
-	if !a.IsInitialized() {
-		return errors.New("veil is not initialized (run `veil init`)")
+type gistBackend struct {
+	gh       *githubClient
+	id       string
+	gist     *gistResponse
+	revision string
+}
+
+func (b *gistBackend) Type() string {
+	return remoteGist
+}
+
+func (b *gistBackend) load() error {
+	if b.gist != nil {
+		return nil
 	}
-	identity, err := a.LoadIdentity()
+	gist, err := b.gh.getGist(b.id)
 	if err != nil {
 		return err
 	}
-	if strings.TrimSpace(token) == "" {
-		token, err = a.LoadGitHubToken()
-		if err != nil {
+	if gist.Truncated {
+		if err := b.gh.cloneGistFiles(gist); err != nil {
 			return err
 		}
 	}
-	if gistID == "" {
-		if a.config.Gist.ID != "" {
-			gistID = a.config.Gist.ID
-		} else {
-			files := map[string]string{recipientsFileName: identity.Recipient().String() + "\n"}
-			gist, err := createGist(token, files)
-			if err != nil {
-				return err
-			}
-			gistID = gist.ID
-			a.config.Gist.Owner = gist.Owner.Login
-		}
+	b.gist = gist
+	b.revision = gist.revision()
+	return nil
+}
+
+// The API stops listing files after 300; the only way to see the rest is the
+// gist's git repository, so a truncated listing is replaced by a shallow clone.
+func (c *githubClient) cloneGistFiles(gist *gistResponse) error {
+	if gist.GitPullURL == "" {
+		return fmt.Errorf("gist %s lists only %d files and has no git url to fetch the rest", gist.ID, len(gist.Files))
 	}
-	gist, err := getGist(token, gistID)
+	dir, err := os.MkdirTemp("", "veil-gist-")
 	if err != nil {
 		return err
 	}
-	a.config.Gist.ID = gist.ID
-	if gist.Owner.Login != "" {
-		a.config.Gist.Owner = gist.Owner.Login
+	defer os.RemoveAll(dir)
+	cmd := exec.Command("git", "clone", "--quiet", "--depth", "1", gist.GitPullURL, dir)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	gitConfig := [][2]string{}
+	if c.token != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + c.token))
+		gitConfig = append(gitConfig, [2]string{"http.extraHeader", "Authorization: Basic " + auth})
+	}
+	if c.proxy != "" {
+		gitConfig = append(gitConfig, [2]string{"http.proxy", c.proxy})
+	}
+	if len(gitConfig) > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(gitConfig)))
+		for i, entry := range gitConfig {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, entry[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, entry[1]))
+		}
 	}
-
-	recipients := []string{identity.Recipient().String()}
-	if file, ok := gist.Files[recipientsFileName]; ok {
-		content := file.Content
-		if content == "" && file.RawURL != "" {
-			if fetched, err := fetchRawContent(file.RawURL); err == nil {
-				content = fetched
-			}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gist %s has more than %d files and cloning it failed: %w: %s", gist.ID, len(gist.Files), err, strings.TrimSpace(stderr.String()))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	files := map[string]gistFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
 		}
-		recipients = append(recipients, strings.Split(content, "\n")...)
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("read cloned gist file: %w", err)
+		}
+		files[entry.Name()] = gistFile{Filename: entry.Name(), Size: len(content), Content: string(content)}
 	}
-	recipients = uniqueStrings(append(a.config.Recipients, recipients...))
-	a.config.Recipients = recipients
+	gist.Files = files
+	gist.Truncated = false
+	return nil
+}
 
-	if err := updateGist(token, gistID, map[string]string{recipientsFileName: strings.Join(recipients, "\n") + "\n"}); err != nil {
-		return err
+func (b *gistBackend) List() ([]string, error) {
+	if err := b.load(); err != nil {
+		return nil, err
 	}
-	return a.SaveConfig()
+	names := make([]string, 0, len(b.gist.Files))
+	for name := range b.gist.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
-func (a *App) Sync(token string) error {
-	if _, err := a.LoadConfig(); err != nil {
-		return err
+func (b *gistBackend) Fetch(name string) (string, error) {
+	if err := b.load(); err != nil {
+		return "", err
 	}
-	if a.config.Gist.ID == "" {
-		return errors.New("no gist connected (run `veil link`)")
+	file, ok := b.gist.Files[name]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", name, errRemoteMissing)
 	}
-	identity, err := a.LoadIdentity()
-	if err != nil {
-		return err
+	if !file.Truncated && (file.Content != "" || file.RawURL == "") {
+		return file.Content, nil
 	}
-	if strings.TrimSpace(token) == "" {
-		token, err = a.LoadGitHubToken()
-		if err != nil {
-			return err
-		}
+	if file.RawURL == "" {
+		return "", fmt.Errorf("%s is truncated and has no raw url", name)
 	}
-	gist, err := getGist(token, a.config.Gist.ID)
+	content, err := b.gh.fetchRaw(file.RawURL)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("fetch %s: %w", name, err)
 	}
-
-	remoteRecipients := []string{}
-	if file, ok := gist.Files[recipientsFileName]; ok {
-		content := file.Content
-		if content == "" && file.RawURL != "" {
-			content, _ = fetchRawContent(file.RawURL)
-		}
-		remoteRecipients = strings.Split(content, "\n")
+	if file.Size > 0 && len(content) != file.Size {
+		return "", fmt.Errorf("fetch %s: got %d of %d bytes", name, len(content), file.Size)
 	}
-	a.config.Recipients = uniqueStrings(append(a.config.Recipients, append(remoteRecipients, identity.Recipient().String())...))
+	return content, nil
+}
 
-	for name, file := range gist.Files {
-		if !strings.HasSuffix(name, ".json.age") {
-			continue
-		}
-		content := file.Content
-		if content == "" && file.RawURL != "" {
-			content, _ = fetchRawContent(file.RawURL)
-		}
-		if strings.TrimSpace(content) == "" {
-			continue
-		}
-		project := strings.TrimSuffix(name, ".json.age")
-		localPath := a.projectFilePath(project)
-		localCipher, _ := os.ReadFile(localPath)
-		if len(localCipher) == 0 {
-			if err := os.WriteFile(localPath, []byte(content), 0o600); err != nil {
-				return err
-			}
-			continue
-		}
-		remotePlain, remoteErr := decryptJSON(content, identity)
-		localPlain, localErr := decryptJSON(string(localCipher), identity)
-		if remoteErr != nil {
-			continue
-		}
-		if localErr != nil {
-			if err := os.WriteFile(localPath, []byte(content), 0o600); err != nil {
-				return err
-			}
-			continue
+// The gist API has no conditional PATCH, so the write cannot be made atomic
+// with the check. Instead the revision seen while reading is checked right
+// before writing, and the history in the PATCH response is checked after: if
+// another write landed in between, the files this PATCH replaced are put back
+// to that write's content and the caller re-runs its pull-merge-push cycle,
+// which then merges both. Only a third write inside the few milliseconds of
+// that repair can still be overwritten; it is reported rather than hidden.
+func (b *gistBackend) Put(files map[string]string) error {
+	read := ""
+	if b.revision != "" {
+		current, err := b.gh.getGist(b.id)
+		if err != nil {
+			return err
 		}
-		var remoteBundle ProjectBundle
-		var localBundle ProjectBundle
-		if json.Unmarshal(remotePlain, &remoteBundle) != nil || json.Unmarshal(localPlain, &localBundle) != nil {
-			continue
+		if current.revision() != b.revision {
+			b.gist = nil
+			return fmt.Errorf("gist %s: %w", b.id, errRemoteChanged)
 		}
-		if latestUpdate(&remoteBundle).After(latestUpdate(&localBundle)) {
-			if err := os.WriteFile(localPath, []byte(content), 0o600); err != nil {
-				return err
-			}
+		if len(current.History) > 0 {
+			read = current.History[0].Version
 		}
 	}
-
-	files := map[string]string{}
-	entries, err := os.ReadDir(a.StoreDir)
+	gist, err := b.gh.updateGist(b.id, files)
 	if err != nil {
 		return err
 	}
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json.age") {
-			continue
-		}
-		path := filepath.Join(a.StoreDir, entry.Name())
-		b, readErr := os.ReadFile(path)
-		if readErr != nil {
-			return readErr
-		}
-		files[entry.Name()] = string(b)
+	b.gist = nil
+	b.revision = gist.revision()
+	if read == "" || len(gist.History) < 2 || gist.History[1].Version == read {
+		return nil
 	}
-	sortedRecipients := append([]string(nil), a.config.Recipients...)
-	sort.Strings(sortedRecipients)
-	files[recipientsFileName] = strings.Join(sortedRecipients, "\n") + "\n"
-	if err := updateGist(token, a.config.Gist.ID, files); err != nil {
+	if err := b.restore(files, gist.History[0].Version, gist.History[1].Version); err != nil {
 		return err
 	}
-	a.config.Gist.LastSyncedAt = nowRFC3339()
-	return a.SaveConfig()
+	return fmt.Errorf("gist %s: %w", b.id, errRemoteChanged)
+}
+
+// restore puts the files of an overlapping write back as they were in the
+// version just before ours.
+func (b *gistBackend) restore(files map[string]string, ours, theirs string) error {
+	previous, err := b.gh.getGistVersion(b.id, theirs)
+	if err != nil {
+		return fmt.Errorf("gist %s was written concurrently and reading that write failed: %w", b.id, err)
+	}
+	before := &gistBackend{gh: b.gh, id: b.id, gist: previous}
+	requestFiles := map[string]any{}
+	for name := range files {
+		content, err := before.Fetch(name)
+		switch {
+		case errors.Is(err, errRemoteMissing):
+			requestFiles[name] = nil
+		case err != nil:
+			return fmt.Errorf("gist %s was written concurrently and reading that write failed: %w", b.id, err)
+		default:
+			requestFiles[name] = map[string]string{"content": content}
+		}
+	}
+	repaired, err := b.gh.patchGist(b.id, requestFiles)
+	if err != nil {
+		return fmt.Errorf("gist %s was written concurrently and restoring that write failed: %w", b.id, err)
+	}
+	if len(repaired.History) < 2 || repaired.History[1].Version != ours {
+		return fmt.Errorf("gist %s kept changing while a concurrent write was restored; run `veil sync` again and check `veil history` for lost values", b.id)
+	}
+	b.revision = repaired.revision()
+	return nil
+}
+
+func (b *gistBackend) Recipients() ([]string, error) {
+	return remoteRecipients(b)
 }
@@ -0,0 +1,356 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGist serves the parts of the gist API veil uses: create, read, read at a
+// version and PATCH, with a history that gets a new version on every write.
+type fakeGist struct {
+	mu       sync.Mutex
+	id       string
+	versions []fakeGistVersion
+	patches  int
+	// onPatch runs once, before the next PATCH is applied and without the
+	// lock held, so a test can land another write in between a backend's
+	// revision check and its PATCH.
+	onPatch func()
+}
+
+type fakeGistVersion struct {
+	version   string
+	updatedAt time.Time
+	files     map[string]string
+}
+
+func newFakeGist(t *testing.T) (*fakeGist, *httptest.Server) {
+	t.Helper()
+	fake := &fakeGist{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, srv
+}
+
+func (f *fakeGist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, `{"message":"Requires authentication"}`, http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "gists":
+		var body struct {
+			Files map[string]*struct{ Content string } `json:"files"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		f.id = "gist1"
+		f.commit(body.Files)
+		f.respond(w, len(f.versions)-1)
+		f.mu.Unlock()
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[1] == f.gistID():
+		f.mu.Lock()
+		f.respond(w, len(f.versions)-1)
+		f.mu.Unlock()
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == f.gistID():
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for i, version := range f.versions {
+			if version.version == parts[2] {
+				f.respond(w, i)
+				return
+			}
+		}
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	case r.Method == http.MethodPatch && len(parts) == 2 && parts[1] == f.gistID():
+		var body struct {
+			Files map[string]*struct{ Content string } `json:"files"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		hook := f.onPatch
+		f.onPatch = nil
+		f.mu.Unlock()
+		if hook != nil {
+			hook()
+		}
+		f.mu.Lock()
+		f.patches++
+		f.commit(body.Files)
+		f.respond(w, len(f.versions)-1)
+		f.mu.Unlock()
+	default:
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	}
+}
+
+func (f *fakeGist) gistID() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.id
+}
+
+// commit applies a write on top of the latest version; a nil file deletes it.
+func (f *fakeGist) commit(changes map[string]*struct{ Content string }) {
+	files := map[string]string{}
+	if n := len(f.versions); n > 0 {
+		for name, content := range f.versions[n-1].files {
+			files[name] = content
+		}
+	}
+	for name, change := range changes {
+		if change == nil {
+			delete(files, name)
+			continue
+		}
+		files[name] = change.Content
+	}
+	f.versions = append(f.versions, fakeGistVersion{
+		version:   fmt.Sprintf("v%d", len(f.versions)+1),
+		updatedAt: time.Now().UTC().Truncate(time.Second),
+		files:     files,
+	})
+}
+
+func (f *fakeGist) write(files map[string]string) {
+	changes := map[string]*struct{ Content string }{}
+	for name, content := range files {
+		changes[name] = &struct{ Content string }{content}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commit(changes)
+}
+
+func (f *fakeGist) files() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.versions[len(f.versions)-1].files
+}
+
+func (f *fakeGist) respond(w http.ResponseWriter, index int) {
+	version := f.versions[index]
+	resp := gistResponse{ID: f.id, UpdatedAt: version.updatedAt.Format(time.RFC3339), Files: map[string]gistFile{}}
+	resp.Owner.Login = "octocat"
+	for name, content := range version.files {
+		resp.Files[name] = gistFile{Filename: name, Size: len(content), Content: content}
+	}
+	for i := index; i >= 0; i-- {
+		resp.History = append(resp.History, struct {
+			Version string `json:"version"`
+		}{f.versions[i].version})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestGistBackend(t *testing.T, srv *httptest.Server, id string) *gistBackend {
+	t.Helper()
+	return &gistBackend{gh: &githubClient{http: srv.Client(), api: srv.URL, token: "token"}, id: id}
+}
+
+func TestGistPutRejectsStaleRevision(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	fake.id = "gist1"
+	fake.write(map[string]string{"app.json.age": "app"})
+
+	first := newTestGistBackend(t, srv, "gist1")
+	second := newTestGistBackend(t, srv, "gist1")
+	for _, backend := range []*gistBackend{first, second} {
+		if _, err := backend.List(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := first.Put(map[string]string{"app.json.age": "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Put(map[string]string{"app.json.age": "second"}); !errors.Is(err, errRemoteChanged) {
+		t.Fatalf("Put after another write = %v, want errRemoteChanged", err)
+	}
+	if got := fake.files()["app.json.age"]; got != "first" {
+		t.Fatalf("app.json.age = %q, want first", got)
+	}
+}
+
+// A write that lands between the revision check and the PATCH is detected from
+// the PATCH response's history and restored before errRemoteChanged.
+func TestGistPutRestoresWriteBetweenCheckAndPatch(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	fake.id = "gist1"
+	fake.write(map[string]string{"app.json.age": "app", "recipients.txt": "key\n"})
+
+	backend := newTestGistBackend(t, srv, "gist1")
+	if _, err := backend.List(); err != nil {
+		t.Fatal(err)
+	}
+	fake.onPatch = func() {
+		fake.write(map[string]string{"app.json.age": "other machine", "other.json.age": "other"})
+	}
+	err := backend.Put(map[string]string{"app.json.age": "ours", "new.json.age": "ours"})
+	if !errors.Is(err, errRemoteChanged) {
+		t.Fatalf("Put = %v, want errRemoteChanged", err)
+	}
+	files := fake.files()
+	if files["app.json.age"] != "other machine" || files["other.json.age"] != "other" || files["recipients.txt"] != "key\n" {
+		t.Fatalf("files after restore = %v", files)
+	}
+	if _, ok := files["new.json.age"]; ok {
+		t.Fatal("new.json.age survived the restore")
+	}
+}
+
+func TestGistClientsHaveTimeout(t *testing.T) {
+	client, err := GitHubConfig{}.httpClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Timeout == 0 {
+		t.Fatal("github client has no timeout")
+	}
+}
+
+// Two machines sync into the same gist with the second one's whole sync
+// landing in the middle of the first one's push. The first push is repaired
+// and retried, and after both settle each machine has both edits.
+func TestInterleavedGistSyncsLoseNoWrites(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	bob := newGistTestApp(t, srv, "bob")
+	if err := bob.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	trustEachOther(t, alice, bob)
+	setTestSecret(t, alice, "api", "SHARED", "v0")
+	syncTest(t, alice)
+	syncTest(t, bob)
+
+	setTestSecret(t, alice, "api", "FROM_ALICE", "a")
+	setTestSecret(t, bob, "api", "FROM_BOB", "b")
+	setTestSecret(t, bob, "api", "SHARED", "bob")
+	var bobErr error
+	fake.onPatch = func() { _, bobErr = bob.Sync(SyncOptions{Token: "token"}) }
+	before := fake.patches
+	syncTest(t, alice)
+	if bobErr != nil {
+		t.Fatalf("interleaved sync: %v", bobErr)
+	}
+	patches := fake.patches - before
+	syncTest(t, bob)
+
+	for _, app := range []*App{alice, bob} {
+		want := map[string]string{"SHARED": "bob", "FROM_ALICE": "a", "FROM_BOB": "b"}
+		for key, value := range want {
+			if got := testSecret(t, app, "api", key); got != value {
+				t.Errorf("%s: %s = %q, want %q", app.config.Machine.Name, key, got, value)
+			}
+		}
+	}
+	// Alice's first PATCH, its repair and the retried PATCH, plus Bob's one.
+	if patches != 4 {
+		t.Errorf("patches during the interleaved syncs = %d, want 4", patches)
+	}
+}
+
+// newTestApp initializes a machine in a fresh VEIL_HOME with a file key.
+func newTestApp(t *testing.T, name string) *App {
+	t.Helper()
+	t.Setenv("VEIL_HOME", t.TempDir())
+	app, err := NewApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DisablePrompts()
+	if err := app.Init(keyStorageFile, name); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func newGistTestApp(t *testing.T, srv *httptest.Server, name string) *App {
+	t.Helper()
+	app := newTestApp(t, name)
+	app.SetHTTPClient(srv.Client())
+	if err := app.SetGitHubConfig(GitHubConfig{APIURL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// trustEachOther approves each machine's keys on the other, as `veil machines
+// approve` would once both have published something.
+func trustEachOther(t *testing.T, apps ...*App) {
+	t.Helper()
+	for _, app := range apps {
+		if _, err := app.LoadConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, app := range apps {
+		for _, other := range apps {
+			if other == app {
+				continue
+			}
+			machine := other.config.Machine
+			app.config.Recipients = uniqueStrings(append(app.config.Recipients, machine.PublicKey))
+			app.config.Signers = append(app.config.Signers, Signer{MachineID: machine.ID, PublicKey: machine.SigningKey})
+			pending := app.config.Pending[:0]
+			for _, p := range app.config.Pending {
+				if p.PublicKey != machine.PublicKey {
+					pending = append(pending, p)
+				}
+			}
+			app.config.Pending = pending
+		}
+		if err := app.SaveConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func setTestSecret(t *testing.T, app *App, project, key, value string) {
+	t.Helper()
+	bundle, err := app.LoadProject(project, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	UpsertSecret(bundle, "", key, value, "")
+	if err := app.SaveProject(bundle); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSecret(t *testing.T, app *App, project, key string) string {
+	t.Helper()
+	bundle, err := app.LoadProject(project, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := GetSecret(bundle, "", key)
+	return secret.Value
+}
+
+func syncTest(t *testing.T, app *App) *SyncReport {
+	t.Helper()
+	report, err := app.Sync(SyncOptions{Token: "token"})
+	if err != nil {
+		t.Fatalf("%s: sync: %v", app.config.Machine.Name, err)
+	}
+	if len(report.Quarantined) > 0 {
+		t.Fatalf("%s: sync quarantined %v", app.config.Machine.Name, report.Quarantined)
+	}
+	return report
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+const maxSecretHistory = 20
+
+// recordVersion keeps the value about to be replaced. History is ordered
+// oldest first and bounded, dropping the oldest versions past the limit.
+func recordVersion(secret *Secret) {
+	history := append(slices.Clone(secret.History), SecretVersion{Value: secret.Value, UpdatedAt: secret.UpdatedAt, UpdatedBy: secret.UpdatedBy})
+	if len(history) > maxSecretHistory {
+		history = history[len(history)-maxSecretHistory:]
+	}
+	secret.History = history
+}
+
+// SecretVersions lists a secret newest first; version 0 is the current value.
+func SecretVersions(secret Secret) []SecretVersion {
+	versions := []SecretVersion{{Value: secret.Value, UpdatedAt: secret.UpdatedAt, UpdatedBy: secret.UpdatedBy}}
+	for i := len(secret.History) - 1; i >= 0; i-- {
+		versions = append(versions, secret.History[i])
+	}
+	return versions
+}
+
+// RestoreVersion sets a key back to an earlier value. The value it replaces is
+// recorded like any other edit, so the rollback itself can be undone.
+func RestoreVersion(bundle *ProjectBundle, env, key string, version int) (SecretVersion, error) {
+	secret, ok := GetSecret(bundle, env, key)
+	if !ok {
+		return SecretVersion{}, fmt.Errorf("key %q not found in %s", key, LayerName(bundle.Project, env))
+	}
+	versions := SecretVersions(secret)
+	if len(versions) == 1 {
+		return SecretVersion{}, fmt.Errorf("%s has no earlier versions", key)
+	}
+	if version < 1 || version >= len(versions) {
+		return SecretVersion{}, fmt.Errorf("%s has versions 1-%d (0 is the current value)", key, len(versions)-1)
+	}
+	restored := versions[version]
+	UpsertSecret(bundle, env, key, restored.Value, "")
+	return restored, nil
+}
+
+// MachineLabel names the machine behind a machine ID for display, falling back
+// to a short ID for machines this one has not heard of.
+func (a *App) MachineLabel(id string) string {
+	if id == "" {
+		return ""
+	}
+	if id == a.config.Machine.ID {
+		return a.config.Machine.Name
+	}
+	for _, record := range a.config.Machines {
+		if record.ID == id && record.Name != "" {
+			return record.Name
+		}
+	}
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// mergeHistory keeps every version either side remembers, including a value
+// that lost a conflict, so nothing a machine wrote disappears in a merge.
+// Versions are matched by value and time since the machine stamp is only
+// added when a bundle is saved.
+func mergeHistory(current Secret, states ...keyState) []SecretVersion {
+	type versionID struct{ value, at string }
+	seen := map[versionID]bool{{current.Value, current.UpdatedAt}: true}
+	versions := []SecretVersion{}
+	add := func(version SecretVersion) {
+		id := versionID{version.Value, version.UpdatedAt}
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		versions = append(versions, version)
+	}
+	for _, state := range states {
+		if !state.present || state.deleted {
+			continue
+		}
+		for _, version := range state.secret.History {
+			add(version)
+		}
+		add(SecretVersion{Value: state.secret.Value, UpdatedAt: state.secret.UpdatedAt, UpdatedBy: state.secret.UpdatedBy})
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].UpdatedAt < versions[j].UpdatedAt })
+	if len(versions) > maxSecretHistory {
+		versions = versions[len(versions)-maxSecretHistory:]
+	}
+	return versions
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecordVersionKeepsNewestVersions(t *testing.T) {
+	bundle := testBundle()
+	for i := 0; i <= maxSecretHistory+4; i++ {
+		UpsertSecret(bundle, "", "A", fmt.Sprintf("v%d", i), "")
+	}
+	secret, _ := GetSecret(bundle, "", "A")
+	if len(secret.History) != maxSecretHistory {
+		t.Fatalf("history has %d versions, want %d", len(secret.History), maxSecretHistory)
+	}
+	versions := SecretVersions(secret)
+	if versions[0].Value != "v24" || versions[1].Value != "v23" || versions[maxSecretHistory].Value != "v4" {
+		t.Fatalf("versions = current %s, then %s ... %s", versions[0].Value, versions[1].Value, versions[maxSecretHistory].Value)
+	}
+}
+
+func withHistory(secret Secret, versions ...SecretVersion) Secret {
+	secret.History = versions
+	return secret
+}
+
+func testVersion(value string, day int) SecretVersion {
+	return SecretVersion{Value: value, UpdatedAt: testTime(day)}
+}
+
+// The merged secret keeps both sides' history once each, including the value
+// that lost the conflict, oldest first.
+func TestMergeHistory(t *testing.T) {
+	shared := []SecretVersion{testVersion("v1", 1), testVersion("v2", 2)}
+	base := testBundle(withHistory(testSecretAt("", "A", "v3", 3), shared...))
+	local := testBundle(withHistory(testSecretAt("", "A", "local", 4), append(shared, testVersion("v3", 3))...))
+	remote := testBundle(withHistory(testSecretAt("", "A", "remote", 5), append(shared, testVersion("v3", 3))...))
+
+	merged, conflicts := mergeBundles(base, local, remote)
+	if len(conflicts) != 1 || conflicts[0].Kept != "remote" {
+		t.Fatalf("conflicts = %+v", conflicts)
+	}
+	secret, _ := GetSecret(merged, "", "A")
+	got := []string{}
+	for _, version := range secret.History {
+		got = append(got, version.Value)
+	}
+	if fmt.Sprint(got) != "[v1 v2 v3 local]" || secret.Value != "remote" {
+		t.Fatalf("merged %s with history %v", secret.Value, got)
+	}
+
+	// Merging the result with either side again adds nothing.
+	again, _ := mergeBundles(merged, merged, local)
+	if secret, _ := GetSecret(again, "", "A"); len(secret.History) != 4 {
+		t.Fatalf("history after a second merge = %+v", secret.History)
+	}
+}
+
+func TestMergeHistoryKeepsNewestVersions(t *testing.T) {
+	var local, remote []SecretVersion
+	for day := 1; day <= 15; day++ {
+		local = append(local, testVersion(fmt.Sprintf("l%d", day), day))
+		remote = append(remote, testVersion(fmt.Sprintf("r%d", day), day))
+	}
+	merged := mergeHistory(testSecretAt("", "A", "current", 20),
+		keyState{secret: withHistory(testSecretAt("", "A", "current", 20), local...), present: true},
+		keyState{secret: withHistory(testSecretAt("", "A", "current", 20), remote...), present: true},
+	)
+	if len(merged) != maxSecretHistory {
+		t.Fatalf("merged %d versions, want %d", len(merged), maxSecretHistory)
+	}
+	if merged[0].UpdatedAt != testTime(6) || merged[len(merged)-1].UpdatedAt != testTime(15) {
+		t.Fatalf("merged history runs from %s to %s", merged[0].UpdatedAt, merged[len(merged)-1].UpdatedAt)
+	}
+}
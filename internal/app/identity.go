@@ -0,0 +1,203 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"filippo.io/age"
+	"github.com/zalando/go-keyring"
+)
+
+type IdentityErrorKind string
+
+const (
+	IdentityLocked       IdentityErrorKind = "locked"
+	IdentityMissing      IdentityErrorKind = "missing"
+	IdentityCorrupt      IdentityErrorKind = "corrupt"
+	IdentityUnreadable   IdentityErrorKind = "unreadable"
+	IdentityWrongMachine IdentityErrorKind = "wrong-machine"
+)
+
+type IdentityError struct {
+	Kind    IdentityErrorKind
+	Storage string
+	Where   string
+	Err     error
+}
+
+func (e *IdentityError) Error() string {
+	switch e.Kind {
+	case IdentityLocked:
+		return fmt.Sprintf("cannot unlock identity in %s: %v", e.Where, e.Err)
+	case IdentityMissing:
+		return fmt.Sprintf("identity not found in %s", e.Where)
+	case IdentityCorrupt:
+		return fmt.Sprintf("identity in %s is corrupt: %v", e.Where, e.Err)
+	case IdentityUnreadable:
+		return fmt.Sprintf("cannot read identity in %s: %v", e.Where, e.Err)
+	case IdentityWrongMachine:
+		return fmt.Sprintf("identity in %s belongs to another machine: %v", e.Where, e.Err)
+	default:
+		return fmt.Sprintf("identity in %s: %v", e.Where, e.Err)
+	}
+}
+
+func (e *IdentityError) Unwrap() error {
+	return e.Err
+}
+
+func (e *IdentityError) Hint() string {
+	switch e.Kind {
+	case IdentityLocked:
+		if e.Storage == keyStoragePassphrase {
+			return "check the passphrase, or set " + passphraseEnv + " when running without a terminal"
+		}
+		if e.Storage == keyStorageFile {
+			return "make sure the key file is readable by your user"
+		}
+		return "unlock the OS keychain (log in to the desktop session or unlock the login keyring) and retry"
+	case IdentityMissing:
+		return "restore this machine's age key to " + e.Where + " from a backup, or re-initialize with a fresh VEIL_HOME and approve it from another machine"
+	case IdentityCorrupt:
+		return "the stored key is not a valid age identity; restore it from a backup"
+	case IdentityUnreadable:
+		return "make sure " + e.Where + " is a regular file on a mounted, readable disk"
+	case IdentityWrongMachine:
+		return "the key store and config.json disagree (was VEIL_HOME copied from another machine?); restore the matching key or config"
+	default:
+		return "run `veil doctor` for details"
+	}
+}
+
+func identityError(kind IdentityErrorKind, storage, where string, err error) error {
+	return &IdentityError{Kind: kind, Storage: storage, Where: where, Err: err}
+}
+
+func (a *App) readIdentity() (*age.X25519Identity, error) {
+	store, err := a.keyStoreFor(a.config.KeyStorage)
+	if err != nil {
+		return nil, err
+	}
+	id, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if got := id.Recipient().String(); got != a.config.Machine.PublicKey {
+		return nil, identityError(IdentityWrongMachine, a.config.KeyStorage, a.identityLocation(),
+			fmt.Errorf("key %s does not match machine key %s", got, a.config.Machine.PublicKey))
+	}
+	return id, nil
+}
+
+func (a *App) identityLocation() string {
+	switch a.config.KeyStorage {
+	case keyStorageKeychain:
+		return "the OS keychain"
+	case keyStorageFile:
+		return a.keyFilePath(".txt")
+	case keyStoragePassphrase:
+		return a.keyFilePath(".age")
+	default:
+		return a.config.KeyStorage
+	}
+}
+
+func keychainLoadError(user string, err error) error {
+	if errors.Is(err, keyring.ErrNotFound) {
+		return identityError(IdentityMissing, keyStorageKeychain, "the OS keychain (entry "+user+")", err)
+	}
+	return identityError(IdentityLocked, keyStorageKeychain, "the OS keychain", err)
+}
+
+func keyFileReadError(storage, path string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return identityError(IdentityMissing, storage, path, err)
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return identityError(IdentityLocked, storage, path, err)
+	}
+	return identityError(IdentityUnreadable, storage, path, err)
+}
+
+type DoctorCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+	Hint   string
+}
+
+func (a *App) Doctor() []DoctorCheck {
+	checks := []DoctorCheck{}
+	if _, err := a.LoadConfig(); err != nil {
+		return append(checks, DoctorCheck{Name: "config", Detail: err.Error(), Hint: "fix or remove " + a.ConfigPath})
+	}
+	if !a.IsInitialized() {
+		return append(checks, DoctorCheck{Name: "config", Detail: "veil is not initialized", Hint: "run `veil init`"})
+	}
+	checks = append(checks, DoctorCheck{Name: "config", OK: true, Detail: a.ConfigPath})
+
+	if !validKeyStorage(a.config.KeyStorage) {
+		return append(checks, DoctorCheck{
+			Name:   "key storage",
+			Detail: fmt.Sprintf("unknown key storage %q", a.config.KeyStorage),
+			Hint:   "set key_storage in config.json to file, keychain or passphrase",
+		})
+	}
+	checks = append(checks, DoctorCheck{Name: "key storage", OK: true, Detail: a.config.KeyStorage + " (" + a.identityLocation() + ")"})
+
+	id, err := a.readIdentity()
+	var idErr *IdentityError
+	switch {
+	case errors.As(err, &idErr):
+		name := "identity"
+		if idErr.Kind == IdentityWrongMachine {
+			name = "machine key"
+		}
+		return append(checks, DoctorCheck{Name: name, Detail: idErr.Error(), Hint: idErr.Hint()})
+	case err != nil:
+		return append(checks, DoctorCheck{Name: "identity", Detail: err.Error()})
+	}
+	checks = append(checks, DoctorCheck{Name: "identity", OK: true, Detail: "loaded"})
+	checks = append(checks, DoctorCheck{Name: "machine key", OK: true, Detail: a.config.Machine.PublicKey})
+
+	if a.config.KeyStorage != keyStorageKeychain && runtime.GOOS != "windows" {
+		path := a.identityLocation()
+		check := DoctorCheck{Name: "key file permissions", OK: true, Detail: filepath.Base(path) + " is 0600"}
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 {
+			check = DoctorCheck{
+				Name:   "key file permissions",
+				Detail: fmt.Sprintf("%s is %04o", path, info.Mode().Perm()),
+				Hint:   "run `chmod 600 " + path + "`",
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	if key := signingPublicKey(id); a.config.Machine.SigningKey != "" && a.config.Machine.SigningKey != key {
+		checks = append(checks, DoctorCheck{
+			Name:   "signing key",
+			Detail: "config.json signing key does not match the identity",
+			Hint:   "run any command that loads the identity to refresh it",
+		})
+	} else {
+		checks = append(checks, DoctorCheck{Name: "signing key", OK: true, Detail: key})
+	}
+
+	if !a.config.Remote.Linked() {
+		checks = append(checks, DoctorCheck{Name: "remote", OK: true, Detail: "not linked"})
+	} else {
+		checks = append(checks, DoctorCheck{Name: "remote", OK: true, Detail: a.config.Remote.String()})
+	}
+	if len(a.config.Pending) > 0 || len(a.config.PendingSigners) > 0 {
+		checks = append(checks, DoctorCheck{
+			Name:   "pending machines",
+			OK:     true,
+			Detail: fmt.Sprintf("%d key(s) awaiting approval", len(a.config.Pending)+len(a.config.PendingSigners)),
+			Hint:   "review them with `veil machines`",
+		})
+	}
+	return checks
+}
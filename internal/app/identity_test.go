@@ -0,0 +1,30 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestKeyFileReadErrorKinds(t *testing.T) {
+	app := newTestApp(t, "alice")
+	path := app.keyFilePath(".txt")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	_, err := app.readIdentity()
+	var idErr *IdentityError
+	if !errors.As(err, &idErr) || idErr.Kind != IdentityUnreadable || idErr.Where != path || idErr.Unwrap() == nil {
+		t.Fatalf("reading a directory as the key file = %#v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.readIdentity(); !errors.As(err, &idErr) || idErr.Kind != IdentityMissing || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("reading a missing key file = %#v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type ResolvedSecret struct {
+	Secret
+	Layer string
+}
+
+// LayerName labels where a resolved secret came from: the project for its
+// base layer, project/env for an environment.
+func LayerName(project, env string) string {
+	if env == "" {
+		return project
+	}
+	return project + "/" + env
+}
+
+func (a *App) loadInclude(name string) (*ProjectBundle, error) {
+	if _, err := os.Stat(a.projectFilePath(name)); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("included bundle %q does not exist (add a secret with `veil set KEY VALUE -p %s` or run `veil sync`)", name, name)
+	}
+	return a.LoadProject(name, a.config.Projects[name])
+}
+
+// ResolveSecrets is the view `veil run` injects. Included bundles apply first
+// in the order they were added (each resolved through its own includes), then
+// the project's base layer, then the environment; later layers win.
+func (a *App) ResolveSecrets(bundle *ProjectBundle, env string) ([]ResolvedSecret, error) {
+	layers := []string{""}
+	if env != "" {
+		layers = append(layers, env)
+	}
+	out := []ResolvedSecret{}
+	index := map[string]int{}
+	applied := map[string]bool{}
+	var visit func(current *ProjectBundle, chain []string) error
+	visit = func(current *ProjectBundle, chain []string) error {
+		for _, name := range current.Includes {
+			name = sanitizeProjectName(name)
+			if slices.Contains(chain, name) {
+				return fmt.Errorf("include cycle: %s", strings.Join(append(chain, name), " -> "))
+			}
+			if applied[name] {
+				continue
+			}
+			included, err := a.loadInclude(name)
+			if err != nil {
+				return err
+			}
+			if err := visit(included, append(slices.Clone(chain), name)); err != nil {
+				return err
+			}
+		}
+		project := sanitizeProjectName(current.Project)
+		applied[project] = true
+		for _, layer := range layers {
+			for _, secret := range current.Secrets {
+				if secret.Env != layer {
+					continue
+				}
+				resolved := ResolvedSecret{Secret: secret, Layer: LayerName(project, layer)}
+				if i, ok := index[secret.Key]; ok {
+					out[i] = resolved
+					continue
+				}
+				index[secret.Key] = len(out)
+				out = append(out, resolved)
+			}
+		}
+		return nil
+	}
+	if err := visit(bundle, []string{sanitizeProjectName(bundle.Project)}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CheckEnv rejects reading an environment that neither the project nor any
+// bundle it includes defines, which would otherwise quietly resolve to the
+// base layer alone. Writes may still start a new environment.
+func (a *App) CheckEnv(bundle *ProjectBundle, env string) error {
+	if env == "" {
+		return nil
+	}
+	envs := []string{}
+	seen := map[string]bool{}
+	var visit func(current *ProjectBundle) error
+	visit = func(current *ProjectBundle) error {
+		envs = append(envs, Environments(current)...)
+		for _, name := range current.Includes {
+			name = sanitizeProjectName(name)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			included, err := a.loadInclude(name)
+			if err != nil {
+				return err
+			}
+			if err := visit(included); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	seen[sanitizeProjectName(bundle.Project)] = true
+	if err := visit(bundle); err != nil {
+		return err
+	}
+	if slices.Contains(envs, env) {
+		return nil
+	}
+	if len(envs) == 0 {
+		return fmt.Errorf("project %q has no environment %q (it only has the base layer)", bundle.Project, env)
+	}
+	envs = uniqueStrings(envs)
+	sort.Strings(envs)
+	return fmt.Errorf("project %q has no environment %q (environments: %s)", bundle.Project, env, strings.Join(envs, ", "))
+}
+
+// ExpandedSecrets is the resolved view with references interpolated, which is
+// what ends up in a process environment.
+func (a *App) ExpandedSecrets(bundle *ProjectBundle, env string) ([]ResolvedSecret, error) {
+	resolved, err := a.ResolveSecrets(bundle, env)
+	if err != nil {
+		return nil, err
+	}
+	return a.InterpolateSecrets(bundle, env, resolved)
+}
+
+// ResolvedBundle flattens the expanded view of one environment for a .env
+// export. JSON exports skip it and carry the project's own layers and include
+// list instead, so they import back as the same project.
+func (a *App) ResolvedBundle(bundle *ProjectBundle, env string) (*ProjectBundle, error) {
+	resolved, err := a.ExpandedSecrets(bundle, env)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]Secret, 0, len(resolved))
+	for _, secret := range resolved {
+		secrets = append(secrets, secret.Secret)
+	}
+	return &ProjectBundle{Project: bundle.Project, Path: bundle.Path, Secrets: secrets}, nil
+}
+
+func (a *App) AddInclude(bundle *ProjectBundle, name string) error {
+	name = sanitizeProjectName(name)
+	if name == sanitizeProjectName(bundle.Project) {
+		return errors.New("a project cannot include itself")
+	}
+	if slices.Contains(bundle.Includes, name) {
+		return fmt.Errorf("%s already includes %s", bundle.Project, name)
+	}
+	if _, err := a.loadInclude(name); err != nil {
+		return err
+	}
+	previous := bundle.Includes
+	bundle.Includes = append(slices.Clone(previous), name)
+	if _, err := a.ResolveSecrets(bundle, ""); err != nil {
+		bundle.Includes = previous
+		return err
+	}
+	bundle.IncludesUpdatedAt = nowRFC3339()
+	return nil
+}
+
+func RemoveInclude(bundle *ProjectBundle, name string) bool {
+	name = sanitizeProjectName(name)
+	idx := slices.Index(bundle.Includes, name)
+	if idx < 0 {
+		return false
+	}
+	bundle.Includes = slices.Delete(slices.Clone(bundle.Includes), idx, idx+1)
+	bundle.IncludesUpdatedAt = nowRFC3339()
+	return true
+}
+
+// Include lists are replaced as a whole, so merging keeps whichever side
+// changed them last.
+func mergeIncludes(local, remote *ProjectBundle) ([]string, string) {
+	switch {
+	case local == nil && remote == nil:
+		return nil, ""
+	case remote == nil:
+		return local.Includes, local.IncludesUpdatedAt
+	case local == nil || remote.IncludesUpdatedAt > local.IncludesUpdatedAt:
+		return remote.Includes, remote.IncludesUpdatedAt
+	default:
+		return local.Includes, local.IncludesUpdatedAt
+	}
+}
+
+func sameIncludes(a, b *ProjectBundle) bool {
+	var left, right []string
+	if a != nil {
+		left = a.Includes
+	}
+	if b != nil {
+		right = b.Includes
+	}
+	return slices.Equal(left, right)
+}
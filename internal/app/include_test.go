@@ -0,0 +1,122 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckEnv(t *testing.T) {
+	app := newTestApp(t, "alice")
+	shared := testBundle(testSecretAt("staging", "TOKEN", "s", 1))
+	shared.Project = "shared"
+	if err := app.SaveProject(shared); err != nil {
+		t.Fatal(err)
+	}
+	own := testBundle(testSecretAt("", "A", "1", 1), testSecretAt("prod", "A", "2", 1))
+	included := testBundle(testSecretAt("", "A", "1", 1), testSecretAt("prod", "A", "2", 1))
+	included.Includes = []string{"shared"}
+
+	tests := []struct {
+		name   string
+		bundle *ProjectBundle
+		env    string
+		err    string
+	}{
+		{name: "base layer", bundle: testBundle(), env: ""},
+		{name: "own environment", bundle: own, env: "prod"},
+		{name: "unknown environment", bundle: own, env: "stagng", err: `project "app" has no environment "stagng" (environments: prod)`},
+		{name: "no environments", bundle: testBundle(testSecretAt("", "A", "1", 1)), env: "prod", err: "it only has the base layer"},
+		{name: "environment of an include", bundle: included, env: "staging"},
+		{name: "unknown with includes", bundle: included, env: "dev", err: "(environments: prod, staging)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := app.CheckEnv(tt.bundle, tt.env)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// Includes apply in the order they were added, each through its own includes,
+// then the project's base layer and then its environment; later layers win.
+func TestResolveSecretsLayering(t *testing.T) {
+	app := newTestApp(t, "alice")
+	save := func(bundle *ProjectBundle, project string, includes ...string) *ProjectBundle {
+		t.Helper()
+		bundle.Project = project
+		bundle.Includes = includes
+		if err := app.SaveProject(bundle); err != nil {
+			t.Fatal(err)
+		}
+		return bundle
+	}
+	save(testBundle(
+		testSecretAt("", "ROOT", "root", 1),
+		testSecretAt("", "SHARED", "root", 1),
+	), "root")
+	save(testBundle(
+		testSecretAt("", "SHARED", "first", 1),
+		testSecretAt("", "INCLUDED", "first", 1),
+		testSecretAt("prod", "INCLUDED", "first/prod", 1),
+	), "first", "root")
+	save(testBundle(
+		testSecretAt("", "SHARED", "second", 1),
+	), "second")
+	bundle := save(testBundle(
+		testSecretAt("", "BASE", "app", 1),
+		testSecretAt("", "OVERRIDE", "app", 1),
+		testSecretAt("prod", "OVERRIDE", "app/prod", 1),
+		testSecretAt("", "INCLUDED", "app", 1),
+	), "app", "first", "second")
+
+	tests := []struct {
+		env  string
+		want []string
+	}{
+		{
+			env:  "",
+			want: []string{"ROOT=root@root", "SHARED=second@second", "INCLUDED=app@app", "BASE=app@app", "OVERRIDE=app@app"},
+		},
+		{
+			env:  "prod",
+			want: []string{"ROOT=root@root", "SHARED=second@second", "INCLUDED=app@app", "BASE=app@app", "OVERRIDE=app/prod@app/prod"},
+		},
+	}
+	for _, tt := range tests {
+		resolved, err := app.ResolveSecrets(bundle, tt.env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, secret := range resolved {
+			got = append(got, secret.Key+"="+secret.Value+"@"+secret.Layer)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("env %q: resolved = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+
+	// Within an include, its environment layer overrides its own base.
+	resolved, err := app.ResolveSecrets(save(testBundle(), "bare", "first"), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range resolved {
+		if secret.Key == "INCLUDED" && secret.Layer != "first/prod" {
+			t.Errorf("INCLUDED resolved from %s, want first/prod", secret.Layer)
+		}
+	}
+
+	save(testBundle(), "root", "app")
+	if _, err := app.ResolveSecrets(bundle, ""); err == nil || !strings.Contains(err.Error(), "include cycle: app -> first -> root -> app") {
+		t.Fatalf("cycle: err = %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ${KEY} refers to a key in the same resolved view, ${project.KEY} to a key in
+// another project's view for the same environment. $${ escapes a literal ${.
+// Only secrets marked with Expand are expanded, so values written before
+// references existed, or that merely contain ${, are passed through as stored.
+var referencePattern = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+type interpolator struct {
+	app   *App
+	env   string
+	views map[string]map[string]Secret
+	done  map[string]string
+}
+
+// SetSecretExpand marks a key in one layer for reference expansion, or clears
+// the mark. Like a metadata edit it bumps updated_at so sync carries it.
+func SetSecretExpand(bundle *ProjectBundle, env, key string, expand bool) (bool, error) {
+	for i := range bundle.Secrets {
+		secret := &bundle.Secrets[i]
+		if secret.Env != env || secret.Key != key {
+			continue
+		}
+		if secret.Expand == expand {
+			return false, nil
+		}
+		secret.Expand = expand
+		secret.UpdatedAt = nowRFC3339()
+		secret.UpdatedBy = ""
+		return true, nil
+	}
+	return false, fmt.Errorf("key %q not found in %s", key, LayerName(bundle.Project, env))
+}
+
+// InterpolateSecrets expands references in an already resolved view. Secrets
+// not marked with Expand are returned untouched.
+func (a *App) InterpolateSecrets(bundle *ProjectBundle, env string, secrets []ResolvedSecret) ([]ResolvedSecret, error) {
+	project := sanitizeProjectName(bundle.Project)
+	own := map[string]Secret{}
+	for _, secret := range secrets {
+		own[secret.Key] = secret.Secret
+	}
+	in := &interpolator{
+		app:   a,
+		env:   env,
+		views: map[string]map[string]Secret{project: own},
+		done:  map[string]string{},
+	}
+	out := make([]ResolvedSecret, 0, len(secrets))
+	for _, secret := range secrets {
+		value, err := in.expand(project, secret.Key, nil)
+		if err != nil {
+			return nil, err
+		}
+		secret.Value = value
+		out = append(out, secret)
+	}
+	return out, nil
+}
+
+func (in *interpolator) view(project string) (map[string]Secret, error) {
+	if view, ok := in.views[project]; ok {
+		return view, nil
+	}
+	if _, err := os.Stat(in.app.projectFilePath(project)); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("project %q does not exist", project)
+	}
+	bundle, err := in.app.LoadProject(project, in.app.config.Projects[project])
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := in.app.ResolveSecrets(bundle, in.env)
+	if err != nil {
+		return nil, err
+	}
+	view := map[string]Secret{}
+	for _, secret := range resolved {
+		view[secret.Key] = secret.Secret
+	}
+	in.views[project] = view
+	return view, nil
+}
+
+func (in *interpolator) expand(project, key string, stack []string) (string, error) {
+	id := project + "." + key
+	if value, ok := in.done[id]; ok {
+		return value, nil
+	}
+	if slices.Contains(stack, id) {
+		return "", fmt.Errorf("reference cycle: %s", strings.Join(append(stack, id), " -> "))
+	}
+	view, err := in.view(project)
+	if err != nil {
+		return "", err
+	}
+	secret := view[key]
+	if !secret.Expand {
+		return secret.Value, nil
+	}
+	raw := secret.Value
+	stack = append(slices.Clone(stack), id)
+	var expandErr error
+	value := referencePattern.ReplaceAllStringFunc(raw, func(match string) string {
+		if expandErr != nil {
+			return match
+		}
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		ref := strings.TrimSpace(match[2 : len(match)-1])
+		refProject, refKey := project, ref
+		if name, rest, ok := strings.Cut(ref, "."); ok {
+			refProject, refKey = sanitizeProjectName(name), rest
+		}
+		if refKey == "" {
+			expandErr = fmt.Errorf("%s: empty reference %s", key, match)
+			return match
+		}
+		refView, err := in.view(refProject)
+		if err != nil {
+			expandErr = fmt.Errorf("%s references %s: %w", key, match, err)
+			return match
+		}
+		if _, ok := refView[refKey]; !ok {
+			expandErr = fmt.Errorf("%s references %s, which is not set in %s", key, match, LayerName(refProject, in.env))
+			return match
+		}
+		resolved, err := in.expand(refProject, refKey, stack)
+		if err != nil {
+			expandErr = err
+			return match
+		}
+		return resolved
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	in.done[id] = value
+	return value, nil
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func expanding(secret Secret) Secret {
+	secret.Expand = true
+	return secret
+}
+
+func TestExpandedSecrets(t *testing.T) {
+	app := newTestApp(t, "alice")
+	shared := testBundle(testSecretAt("", "TOKEN", "base-token", 1), testSecretAt("prod", "TOKEN", "prod-token", 1))
+	shared.Project = "shared"
+	if err := app.SaveProject(shared); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		secrets []Secret
+		want    string
+		err     string
+	}{
+		{
+			name:    "same project reference",
+			secrets: []Secret{testSecretAt("", "HOST", "db", 1), expanding(testSecretAt("", "OUT", "${HOST}:5432", 1))},
+			want:    "db:5432",
+		},
+		{
+			name:    "nested references",
+			secrets: []Secret{testSecretAt("", "HOST", "db", 1), expanding(testSecretAt("", "ADDR", "${HOST}:5432", 1)), expanding(testSecretAt("", "OUT", "pg://${ADDR}", 1))},
+			want:    "pg://db:5432",
+		},
+		{
+			name:    "unmarked secret is passed through",
+			secrets: []Secret{testSecretAt("", "HOST", "db", 1), testSecretAt("", "OUT", "${HOST}", 1)},
+			want:    "${HOST}",
+		},
+		{
+			name:    "unmarked reference target is not expanded",
+			secrets: []Secret{testSecretAt("", "HOST", "${OTHER}", 1), expanding(testSecretAt("", "OUT", "${HOST}", 1))},
+			want:    "${OTHER}",
+		},
+		{
+			name:    "escaped reference",
+			secrets: []Secret{expanding(testSecretAt("", "OUT", "$${HOST}", 1))},
+			want:    "${HOST}",
+		},
+		{
+			name:    "other project",
+			secrets: []Secret{expanding(testSecretAt("", "OUT", "${shared.TOKEN}", 1))},
+			want:    "base-token",
+		},
+		{
+			name:    "other project in the same environment",
+			env:     "prod",
+			secrets: []Secret{expanding(testSecretAt("prod", "OUT", "${shared.TOKEN}", 1))},
+			want:    "prod-token",
+		},
+		{
+			name:    "missing key",
+			secrets: []Secret{expanding(testSecretAt("", "OUT", "${NOPE}", 1))},
+			err:     "OUT references ${NOPE}, which is not set in app",
+		},
+		{
+			name:    "missing key in another project",
+			secrets: []Secret{expanding(testSecretAt("", "OUT", "${shared.NOPE}", 1))},
+			err:     "which is not set in shared",
+		},
+		{
+			name:    "missing project",
+			secrets: []Secret{expanding(testSecretAt("", "OUT", "${ghost.KEY}", 1))},
+			err:     `project "ghost" does not exist`,
+		},
+		{
+			name:    "empty reference",
+			secrets: []Secret{expanding(testSecretAt("", "OUT", "${}", 1))},
+			err:     "empty reference",
+		},
+		{
+			name:    "cycle",
+			secrets: []Secret{expanding(testSecretAt("", "A", "${OUT}", 1)), expanding(testSecretAt("", "OUT", "${A}", 1))},
+			err:     "reference cycle: app.A -> app.OUT -> app.A",
+		},
+		{
+			name:    "self reference",
+			secrets: []Secret{expanding(testSecretAt("", "OUT", "x${OUT}", 1))},
+			err:     "reference cycle: app.OUT -> app.OUT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := testBundle()
+			bundle.Secrets = tt.secrets
+			resolved, err := app.ExpandedSecrets(bundle, tt.env)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range resolved {
+				if secret.Key == "OUT" {
+					if secret.Value != tt.want {
+						t.Fatalf("OUT = %q, want %q", secret.Value, tt.want)
+					}
+					return
+				}
+			}
+			t.Fatal("OUT missing from the resolved view")
+		})
+	}
+}
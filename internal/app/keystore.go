@@ -0,0 +1,307 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/charmbracelet/x/term"
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	keyStorageFile       = "file"
+	keyStorageKeychain   = "keychain"
+	keyStoragePassphrase = "passphrase"
+	passphraseEnv        = "VEIL_PASSPHRASE"
+)
+
+var keyStorageKinds = []string{keyStorageFile, keyStorageKeychain, keyStoragePassphrase}
+
+type keyStore interface {
+	Save(id *age.X25519Identity) (string, error)
+	Load() (*age.X25519Identity, error)
+	Remove() error
+}
+
+func validKeyStorage(kind string) bool {
+	for _, known := range keyStorageKinds {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) keyStoreFor(kind string) (keyStore, error) {
+	switch kind {
+	case keyStorageKeychain:
+		return keychainKeyStore{user: "age_" + a.config.Machine.ID}, nil
+	case keyStorageFile:
+		return fileKeyStore{path: a.keyFilePath(".txt")}, nil
+	case keyStoragePassphrase:
+		return passphraseKeyStore{path: a.keyFilePath(".age"), app: a}, nil
+	default:
+		return nil, fmt.Errorf("invalid key storage %q (use %s)", kind, strings.Join(keyStorageKinds, ", "))
+	}
+}
+
+func (a *App) keyFilePath(ext string) string {
+	if a.config.KeyFile != "" && filepath.Ext(a.config.KeyFile) == ext {
+		return a.config.KeyFile
+	}
+	return filepath.Join(a.HomeDir, "keys", a.config.Machine.ID+ext)
+}
+
+type keychainKeyStore struct {
+	user string
+}
+
+func (s keychainKeyStore) Save(id *age.X25519Identity) (string, error) {
+	if err := keyring.Set(serviceName, s.user, id.String()); err != nil {
+		return "", fmt.Errorf("save identity to keychain: %w", err)
+	}
+	return "", nil
+}
+
+func (s keychainKeyStore) Load() (*age.X25519Identity, error) {
+	secret, err := keyring.Get(serviceName, s.user)
+	if err != nil {
+		return nil, keychainLoadError(s.user, err)
+	}
+	id, err := age.ParseX25519Identity(strings.TrimSpace(secret))
+	if err != nil {
+		return nil, identityError(IdentityCorrupt, keyStorageKeychain, "the OS keychain", err)
+	}
+	return id, nil
+}
+
+func (s keychainKeyStore) Remove() error {
+	if err := keyring.Delete(serviceName, s.user); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("remove identity from keychain: %w", err)
+	}
+	return nil
+}
+
+type fileKeyStore struct {
+	path string
+}
+
+func (s fileKeyStore) Save(id *age.X25519Identity) (string, error) {
+	if err := writeKeyFile(s.path, []byte(id.String()+"\n")); err != nil {
+		return "", err
+	}
+	return s.path, nil
+}
+
+func (s fileKeyStore) Load() (*age.X25519Identity, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, keyFileReadError(keyStorageFile, s.path, err)
+	}
+	id, err := age.ParseX25519Identity(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, identityError(IdentityCorrupt, keyStorageFile, s.path, err)
+	}
+	return id, nil
+}
+
+func (s fileKeyStore) Remove() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove identity file: %w", err)
+	}
+	return nil
+}
+
+type passphraseKeyStore struct {
+	path string
+	app  *App
+}
+
+func (s passphraseKeyStore) Save(id *age.X25519Identity) (string, error) {
+	passphrase, err := s.app.sessionPassphrase(true)
+	if err != nil {
+		return "", err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return "", fmt.Errorf("wrap identity: %w", err)
+	}
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, recipient)
+	if err != nil {
+		return "", fmt.Errorf("wrap identity: %w", err)
+	}
+	if _, err := io.WriteString(w, id.String()+"\n"); err != nil {
+		return "", fmt.Errorf("wrap identity: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("wrap identity: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return "", fmt.Errorf("wrap identity: %w", err)
+	}
+	if err := writeKeyFile(s.path, out.Bytes()); err != nil {
+		return "", err
+	}
+	return s.path, nil
+}
+
+func (s passphraseKeyStore) Load() (*age.X25519Identity, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, keyFileReadError(keyStoragePassphrase, s.path, err)
+	}
+	passphrase, err := s.app.sessionPassphrase(false)
+	if err != nil {
+		return nil, identityError(IdentityLocked, keyStoragePassphrase, s.path, err)
+	}
+	scrypt, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap identity: %w", err)
+	}
+	r, err := age.Decrypt(armor.NewReader(bytes.NewReader(b)), scrypt)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			s.app.passphrase = ""
+			return nil, identityError(IdentityLocked, keyStoragePassphrase, s.path, errors.New("wrong passphrase"))
+		}
+		return nil, identityError(IdentityCorrupt, keyStoragePassphrase, s.path, err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, identityError(IdentityCorrupt, keyStoragePassphrase, s.path, err)
+	}
+	id, err := age.ParseX25519Identity(strings.TrimSpace(string(plain)))
+	if err != nil {
+		return nil, identityError(IdentityCorrupt, keyStoragePassphrase, s.path, err)
+	}
+	return id, nil
+}
+
+func (s passphraseKeyStore) Remove() error {
+	return fileKeyStore{path: s.path}.Remove()
+}
+
+func writeKeyFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return fmt.Errorf("write identity file: %w", err)
+	}
+	if runtime.GOOS != "windows" {
+		_ = os.Chmod(path, 0o600)
+	}
+	return nil
+}
+
+func (a *App) sessionPassphrase(confirm bool) (string, error) {
+	if a.passphrase != "" {
+		return a.passphrase, nil
+	}
+	if value := os.Getenv(passphraseEnv); value != "" {
+		a.passphrase = value
+		return value, nil
+	}
+	if a.noPrompt || !term.IsTerminal(os.Stdin.Fd()) {
+		return "", fmt.Errorf("identity is passphrase protected: set %s or run in a terminal", passphraseEnv)
+	}
+	passphrase, err := readPassphrase("Veil passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		again, err := readPassphrase("Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	a.passphrase = passphrase
+	return passphrase, nil
+}
+
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	return string(b), nil
+}
+
+func (a *App) SetPassphrase(passphrase string) {
+	a.passphrase = passphrase
+}
+
+func (a *App) DisablePrompts() {
+	a.noPrompt = true
+}
+
+func (a *App) MigrateKeyStorage(kind string) error {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if !validKeyStorage(kind) {
+		return fmt.Errorf("invalid key storage %q (use %s)", kind, strings.Join(keyStorageKinds, ", "))
+	}
+	identity, err := a.LoadIdentity()
+	if err != nil {
+		return err
+	}
+	if kind == a.config.KeyStorage {
+		return nil
+	}
+	oldStore, err := a.keyStoreFor(a.config.KeyStorage)
+	if err != nil {
+		return err
+	}
+	oldKeyFile := a.config.KeyFile
+	a.config.KeyFile = ""
+	newStore, err := a.keyStoreFor(kind)
+	a.config.KeyFile = oldKeyFile
+	if err != nil {
+		return err
+	}
+	keyFile, err := newStore.Save(identity)
+	if err != nil {
+		return err
+	}
+	readBack, err := newStore.Load()
+	if err != nil || readBack.String() != identity.String() {
+		_ = newStore.Remove()
+		if err == nil {
+			err = errors.New("identity read back does not match")
+		}
+		return fmt.Errorf("verify %s key storage: %w", kind, err)
+	}
+	a.config.KeyStorage = kind
+	a.config.KeyFile = keyFile
+	if err := a.SaveConfig(); err != nil {
+		_ = newStore.Remove()
+		return err
+	}
+	if err := oldStore.Remove(); err != nil {
+		return fmt.Errorf("identity moved to %s but the old copy remains: %w", kind, err)
+	}
+	if oldKeyFile != "" && oldKeyFile != keyFile {
+		if err := (fileKeyStore{path: oldKeyFile}).Remove(); err != nil {
+			return fmt.Errorf("identity moved to %s but the old copy remains: %w", kind, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+const machinesFileName = "machines.json"
+
+type MachineRecord struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	PublicKey  string `json:"public_key"`
+	SigningKey string `json:"signing_key,omitempty"`
+	AddedAt    string `json:"added_at"`
+	LastSeenAt string `json:"last_seen_at,omitempty"`
+	UpdatedAt  string `json:"updated_at,omitempty"`
+}
+
+type MachineInfo struct {
+	MachineRecord
+	Status  string
+	Current bool
+}
+
+// mergeRemoteMachines takes in names and last-seen times from machines.json.
+// The file is unsigned, so a record never moves a machine this one already
+// knows to other keys unless the new key is already trusted here; a record
+// that tries is left unapplied, its key is held as pending like any unknown
+// key, and the returned keys and warnings say so.
+func (a *App) mergeRemoteMachines(content string) ([]string, []string) {
+	if strings.TrimSpace(content) == "" {
+		return nil, nil
+	}
+	var remote []MachineRecord
+	if err := json.Unmarshal([]byte(content), &remote); err != nil {
+		return nil, nil
+	}
+	var pending, warnings []string
+	for _, record := range remote {
+		if record.ID == "" {
+			continue
+		}
+		idx := a.machineIndex(record.ID)
+		if record.ID == a.config.Machine.ID {
+			// A rename of this machine made elsewhere wins when it is newer
+			// than the record this machine last published.
+			if record.PublicKey == a.config.Machine.PublicKey && record.Name != "" && (idx == -1 || recordTime(record.UpdatedAt).After(recordTime(a.config.Machines[idx].UpdatedAt))) {
+				a.config.Machine.Name = record.Name
+				if idx >= 0 {
+					a.config.Machines[idx].Name = record.Name
+					a.config.Machines[idx].UpdatedAt = record.UpdatedAt
+				}
+			}
+			continue
+		}
+		if idx == -1 {
+			a.config.Machines = append(a.config.Machines, record)
+			continue
+		}
+		local := a.config.Machines[idx]
+		if (record.PublicKey != local.PublicKey || record.SigningKey != local.SigningKey) && !a.isTrustedRecipient(record.PublicKey) {
+			if added := a.mergeRemoteRecipients([]string{record.PublicKey}); len(added) > 0 {
+				pending = append(pending, added...)
+				warnings = append(warnings, fmt.Sprintf("machines.json names a new key %s for machine %s (%s); the record was not changed and the key is pending approval", MaskValue(record.PublicKey), local.Name, local.ID))
+			}
+			continue
+		}
+		if recordTime(record.UpdatedAt).After(recordTime(local.UpdatedAt)) {
+			local.Name = record.Name
+			local.PublicKey = record.PublicKey
+			local.SigningKey = record.SigningKey
+			local.UpdatedAt = record.UpdatedAt
+		}
+		if recordTime(record.LastSeenAt).After(recordTime(local.LastSeenAt)) {
+			local.LastSeenAt = record.LastSeenAt
+		}
+		a.config.Machines[idx] = local
+	}
+	return pending, warnings
+}
+
+// touchSelfMachine refreshes last_seen_at. updated_at only moves when this
+// machine changed its own record, so a newer rename from elsewhere, already
+// taken in by mergeRemoteMachines, is not overwritten.
+func (a *App) touchSelfMachine() {
+	now := nowRFC3339()
+	self := MachineRecord{
+		ID:         a.config.Machine.ID,
+		Name:       a.config.Machine.Name,
+		PublicKey:  a.config.Machine.PublicKey,
+		SigningKey: a.config.Machine.SigningKey,
+		AddedAt:    a.config.Machine.AddedAt,
+		LastSeenAt: now,
+		UpdatedAt:  now,
+	}
+	if idx := a.machineIndex(self.ID); idx >= 0 {
+		current := a.config.Machines[idx]
+		if current.Name == self.Name && current.PublicKey == self.PublicKey && current.SigningKey == self.SigningKey {
+			self.UpdatedAt = current.UpdatedAt
+		}
+		a.config.Machines[idx] = self
+		return
+	}
+	a.config.Machines = append(a.config.Machines, self)
+}
+
+// publishedMachines carries every record this machine knows except denied
+// ones. machines.json only names machines and grants no trust, and since
+// recipients.txt is limited to the writer's trusted keys, dropping records
+// the same way would erase machines the writer has not approved yet.
+func (a *App) publishedMachines() string {
+	records := []MachineRecord{}
+	for _, record := range a.config.Machines {
+		if !a.isDeniedRecipient(record.PublicKey) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	b, _ := json.MarshalIndent(records, "", "  ")
+	return string(b) + "\n"
+}
+
+func (a *App) dropRecipient(key string) {
+	recipients := make([]string, 0, len(a.config.Recipients))
+	for _, recipient := range a.config.Recipients {
+		if recipient != key {
+			recipients = append(recipients, recipient)
+		}
+	}
+	a.config.Recipients = recipients
+	pending := make([]PendingRecipient, 0, len(a.config.Pending))
+	for _, p := range a.config.Pending {
+		if p.PublicKey != key {
+			pending = append(pending, p)
+		}
+	}
+	a.config.Pending = pending
+	for _, record := range a.config.Machines {
+		if record.PublicKey != key {
+			continue
+		}
+		signers := make([]Signer, 0, len(a.config.Signers))
+		for _, signer := range a.config.Signers {
+			if signer.MachineID != record.ID {
+				signers = append(signers, signer)
+			}
+		}
+		a.config.Signers = signers
+	}
+}
+
+func (a *App) machineIndex(id string) int {
+	for i, record := range a.config.Machines {
+		if record.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func recordTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (a *App) Machines() ([]MachineInfo, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return nil, err
+	}
+	byKey := map[string]MachineRecord{}
+	for _, record := range a.config.Machines {
+		byKey[record.PublicKey] = record
+	}
+	byKey[a.config.Machine.PublicKey] = a.selfRecord()
+	out := []MachineInfo{}
+	add := func(key, status string) {
+		record, ok := byKey[key]
+		if !ok {
+			record = MachineRecord{PublicKey: key, Name: "unknown"}
+		}
+		out = append(out, MachineInfo{
+			MachineRecord: record,
+			Status:        status,
+			Current:       key == a.config.Machine.PublicKey,
+		})
+	}
+	for _, key := range a.config.Recipients {
+		add(key, "trusted")
+	}
+	for _, pending := range a.config.Pending {
+		add(pending.PublicKey, "pending")
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Current != out[j].Current {
+			return out[i].Current
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+func (a *App) selfRecord() MachineRecord {
+	record := MachineRecord{
+		ID:         a.config.Machine.ID,
+		Name:       a.config.Machine.Name,
+		PublicKey:  a.config.Machine.PublicKey,
+		SigningKey: a.config.Machine.SigningKey,
+		AddedAt:    a.config.Machine.AddedAt,
+	}
+	if idx := a.machineIndex(record.ID); idx >= 0 {
+		record.LastSeenAt = a.config.Machines[idx].LastSeenAt
+	}
+	return record
+}
+
+func (a *App) findMachine(query string) (MachineInfo, error) {
+	machines, err := a.Machines()
+	if err != nil {
+		return MachineInfo{}, err
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return MachineInfo{}, errors.New("missing machine id, name or public key")
+	}
+	matches := []MachineInfo{}
+	for _, machine := range machines {
+		if (machine.ID != "" && machine.ID == query) || strings.EqualFold(machine.Name, query) || strings.HasPrefix(machine.PublicKey, query) {
+			matches = append(matches, machine)
+		}
+	}
+	if len(matches) == 0 {
+		return MachineInfo{}, fmt.Errorf("no machine matches %q", query)
+	}
+	if len(matches) > 1 {
+		return MachineInfo{}, fmt.Errorf("%q matches more than one machine", query)
+	}
+	return matches[0], nil
+}
+
+func (a *App) RenameMachine(query, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("machine name cannot be empty")
+	}
+	machine, err := a.findMachine(query)
+	if err != nil {
+		return "", err
+	}
+	if machine.Current {
+		a.config.Machine.Name = name
+		a.touchSelfMachine()
+		return machine.Name, a.SaveConfig()
+	}
+	if machine.ID == "" {
+		return "", fmt.Errorf("machine %s has not published a record yet", MaskValue(machine.PublicKey))
+	}
+	idx := a.machineIndex(machine.ID)
+	a.config.Machines[idx].Name = name
+	a.config.Machines[idx].UpdatedAt = nowRFC3339()
+	return machine.Name, a.SaveConfig()
+}
+
+func (a *App) RevokeMachine(query string, opts SyncOptions) (*SyncReport, error) {
+	machine, err := a.findMachine(query)
+	if err != nil {
+		return nil, err
+	}
+	if machine.Current {
+		return nil, errors.New("cannot revoke the current machine (use `veil rotate-key` to replace its key)")
+	}
+	identity, err := a.LoadIdentity()
+	if err != nil {
+		return nil, err
+	}
+	a.revoke(identity, Revocation{PublicKey: machine.PublicKey, SigningKey: machine.SigningKey, MachineID: machine.ID})
+	a.dropRecipient(machine.PublicKey)
+	a.config.Denied = uniqueStrings(append(a.config.Denied, machine.PublicKey))
+	if machine.ID != "" {
+		if machine.SigningKey != "" {
+			a.config.Denied = uniqueStrings(append(a.config.Denied, machine.SigningKey))
+		}
+		if idx := a.machineIndex(machine.ID); idx >= 0 {
+			a.config.Machines = append(a.config.Machines[:idx], a.config.Machines[idx+1:]...)
+		}
+	}
+	if err := a.reencryptStore(identity); err != nil {
+		return nil, err
+	}
+	if err := a.SaveConfig(); err != nil {
+		return nil, err
+	}
+	if !a.config.Remote.Linked() {
+		return &SyncReport{}, nil
+	}
+	return a.Sync(opts)
+}
+
+func (a *App) reencryptStore(identity *age.X25519Identity) error {
+	names, err := a.localProjectNames()
+	if err != nil {
+		return err
+	}
+	recipients := uniqueStrings(append(a.config.Recipients, identity.Recipient().String()))
+	for _, name := range names {
+		path := a.projectFilePath(name)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read project file: %w", err)
+		}
+		bundle, _, err := decodeBundle(string(b), identity)
+		if err != nil {
+			return fmt.Errorf("project %q: %w", name, err)
+		}
+		ciphertext, err := a.encodeBundle(bundle, recipients, identity)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, []byte(ciphertext)); err != nil {
+			return fmt.Errorf("write project file: %w", err)
+		}
+	}
+	return nil
+}
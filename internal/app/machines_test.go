@@ -0,0 +1,75 @@
+package app
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func testRecipient(t *testing.T) string {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity.Recipient().String()
+}
+
+func machinesJSON(t *testing.T, records ...MachineRecord) string {
+	t.Helper()
+	b, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// An unsigned machines.json record cannot move a known machine ID to another
+// key, and approving by ID still approves the key that was listed for it.
+func TestMergeRemoteMachinesKeepsKnownKeys(t *testing.T) {
+	_, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if _, err := alice.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	bobKey, injected := testRecipient(t), testRecipient(t)
+	bob := MachineRecord{ID: "bob-id", Name: "bob", PublicKey: bobKey, UpdatedAt: "2026-01-01T00:00:00Z"}
+	alice.mergeRemoteRecipients([]string{bobKey})
+	alice.mergeRemoteMachines(machinesJSON(t, bob))
+
+	forged := bob
+	forged.Name = "bob-renamed"
+	forged.PublicKey = injected
+	forged.UpdatedAt = "2026-02-01T00:00:00Z"
+	pending, warnings := alice.mergeRemoteMachines(machinesJSON(t, forged))
+	if len(pending) != 1 || pending[0] != injected || len(warnings) != 1 || !strings.Contains(warnings[0], "bob-id") {
+		t.Fatalf("pending = %v, warnings = %v", pending, warnings)
+	}
+	record := alice.config.Machines[alice.machineIndex("bob-id")]
+	if record.PublicKey != bobKey || record.Name != "bob" {
+		t.Fatalf("record after forged update = %+v", record)
+	}
+	// The same record again warns only once.
+	if pending, warnings := alice.mergeRemoteMachines(machinesJSON(t, forged)); len(pending) != 0 || len(warnings) != 0 {
+		t.Fatalf("repeat merge: pending = %v, warnings = %v", pending, warnings)
+	}
+
+	approved, err := alice.ApproveMachine("bob-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved != bobKey || !alice.isTrustedRecipient(bobKey) || alice.isTrustedRecipient(injected) || !alice.isPendingRecipient(injected) {
+		t.Fatalf("approve bob-id approved %q", approved)
+	}
+
+	// Once the new key is trusted here, the record may move to it.
+	if _, err := alice.ApproveMachine(injected); err != nil {
+		t.Fatal(err)
+	}
+	alice.mergeRemoteMachines(machinesJSON(t, forged))
+	if record := alice.config.Machines[alice.machineIndex("bob-id")]; record.PublicKey != injected || record.Name != "bob-renamed" {
+		t.Fatalf("record after approving the new key = %+v", record)
+	}
+}
@@ -0,0 +1,310 @@
+package app
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// States are indexed by environment and key so the same key in two
+// environments merges independently.
+func stateID(env, key string) string {
+	if env == "" {
+		return key
+	}
+	return env + "\x00" + key
+}
+
+func splitStateID(id string) (string, string) {
+	if env, key, ok := strings.Cut(id, "\x00"); ok {
+		return env, key
+	}
+	return "", id
+}
+
+type keyState struct {
+	secret    Secret
+	tombstone Tombstone
+	present   bool
+	deleted   bool
+}
+
+func mergeBundles(base, local, remote *ProjectBundle) (*ProjectBundle, []SyncConflict) {
+	merged := &ProjectBundle{Secrets: []Secret{}}
+	switch {
+	case local != nil:
+		merged.Project = local.Project
+		merged.Path = local.Path
+	case remote != nil:
+		merged.Project = remote.Project
+		merged.Path = remote.Path
+	}
+	if merged.Path == "" && remote != nil {
+		merged.Path = remote.Path
+	}
+	merged.Includes, merged.IncludesUpdatedAt = mergeIncludes(local, remote)
+
+	baseStates := indexStates(base)
+	localStates := indexStates(local)
+	remoteStates := indexStates(remote)
+
+	keys := make([]string, 0, len(localStates)+len(remoteStates))
+	seen := map[string]struct{}{}
+	for _, index := range []map[string]keyState{baseStates, localStates, remoteStates} {
+		for key := range index {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	conflicts := []SyncConflict{}
+	for _, id := range keys {
+		b := baseStates[id]
+		l := localStates[id]
+		r := remoteStates[id]
+		var result keyState
+		switch {
+		case sameState(l, r):
+			result = combineStates(l, r)
+		case sameState(l, b):
+			result = r
+		case sameState(r, b):
+			result = l
+		default:
+			result = l
+			kept := "local"
+			if !l.present || (r.present && stateTime(r).After(stateTime(l))) {
+				result = r
+				kept = "remote"
+			}
+			env, key := splitStateID(id)
+			conflicts = append(conflicts, SyncConflict{Project: merged.Project, Env: env, Key: key, Kept: kept})
+		}
+		switch {
+		case result.deleted:
+			merged.Tombstones = append(merged.Tombstones, result.tombstone)
+		case result.present:
+			result.secret.History = mergeHistory(result.secret, l, r)
+			merged.Secrets = append(merged.Secrets, result.secret)
+		}
+	}
+	return merged, conflicts
+}
+
+const (
+	ChangePull     = "pull"
+	ChangePush     = "push"
+	ChangeConflict = "conflict"
+	ChangeDelete   = "delete"
+)
+
+// planChanges describes what a merge result does to each side, with values
+// masked so the plan can be printed or shown in the TUI.
+func planChanges(project string, local, remote, result *ProjectBundle, conflicts []SyncConflict) []SyncChange {
+	localStates := indexStates(local)
+	remoteStates := indexStates(remote)
+	resultStates := indexStates(result)
+	conflicted := map[string]string{}
+	for _, conflict := range conflicts {
+		conflicted[stateID(conflict.Env, conflict.Key)] = conflict.Kept
+	}
+
+	keys := make([]string, 0, len(resultStates))
+	seen := map[string]struct{}{}
+	for _, index := range []map[string]keyState{localStates, remoteStates, resultStates} {
+		for key := range index {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []SyncChange{}
+	if result != nil {
+		included := strings.Join(result.Includes, ", ")
+		if !sameIncludes(local, result) {
+			changes = append(changes, SyncChange{Project: project, Key: "(includes)", Action: ChangePull, Value: included})
+		}
+		if !sameIncludes(remote, result) {
+			changes = append(changes, SyncChange{Project: project, Key: "(includes)", Action: ChangePush, Value: included})
+		}
+	}
+	for _, id := range keys {
+		l := localStates[id]
+		r := remoteStates[id]
+		res := resultStates[id]
+		env, key := splitStateID(id)
+		value := ""
+		if res.present && !res.deleted {
+			value = MaskValue(res.secret.Value)
+		}
+		if kept, ok := conflicted[id]; ok {
+			changes = append(changes, SyncChange{Project: project, Env: env, Key: key, Action: ChangeConflict, Value: value, Detail: "keeping " + kept})
+			continue
+		}
+		if res.present && !res.deleted {
+			if !sameState(l, res) {
+				changes = append(changes, SyncChange{Project: project, Env: env, Key: key, Action: ChangePull, Value: value})
+			}
+			if !sameState(r, res) {
+				changes = append(changes, SyncChange{Project: project, Env: env, Key: key, Action: ChangePush, Value: value})
+			}
+			continue
+		}
+		if l.present && !l.deleted {
+			changes = append(changes, SyncChange{Project: project, Env: env, Key: key, Action: ChangeDelete, Detail: "local"})
+		}
+		if r.present && !r.deleted {
+			changes = append(changes, SyncChange{Project: project, Env: env, Key: key, Action: ChangeDelete, Detail: "remote"})
+		}
+	}
+	return changes
+}
+
+func indexStates(bundle *ProjectBundle) map[string]keyState {
+	out := map[string]keyState{}
+	if bundle == nil {
+		return out
+	}
+	for _, tombstone := range bundle.Tombstones {
+		out[stateID(tombstone.Env, tombstone.Key)] = keyState{tombstone: tombstone, present: true, deleted: true}
+	}
+	for _, secret := range bundle.Secrets {
+		id := stateID(secret.Env, secret.Key)
+		if existing, ok := out[id]; ok && existing.deleted && !secretTime(secret).After(tombstoneTime(existing.tombstone)) {
+			continue
+		}
+		out[id] = keyState{secret: secret, present: true}
+	}
+	return out
+}
+
+func sameState(a, b keyState) bool {
+	if a.present != b.present || a.deleted != b.deleted {
+		return false
+	}
+	if !a.present || a.deleted {
+		return true
+	}
+	return a.secret.Value == b.secret.Value && a.secret.Group == b.secret.Group && a.secret.Expand == b.secret.Expand && sameMetadata(MetadataOf(a.secret), MetadataOf(b.secret))
+}
+
+func combineStates(a, b keyState) keyState {
+	if !a.present {
+		return a
+	}
+	if a.deleted {
+		out := a
+		if stateTime(b).After(stateTime(a)) {
+			out = b
+		}
+		out.tombstone.SeenBy = uniqueStrings(append(append([]string(nil), a.tombstone.SeenBy...), b.tombstone.SeenBy...))
+		return out
+	}
+	if stateTime(b).After(stateTime(a)) {
+		return b
+	}
+	return a
+}
+
+func stateTime(state keyState) time.Time {
+	if state.deleted {
+		return tombstoneTime(state.tombstone)
+	}
+	return secretTime(state.secret)
+}
+
+func secretTime(secret Secret) time.Time {
+	t, err := time.Parse(time.RFC3339, secret.UpdatedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func tombstoneTime(tombstone Tombstone) time.Time {
+	t, err := time.Parse(time.RFC3339, tombstone.DeletedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func normalizeBundle(bundle *ProjectBundle) {
+	states := indexStates(bundle)
+	secrets := make([]Secret, 0, len(bundle.Secrets))
+	for _, secret := range bundle.Secrets {
+		if state := states[stateID(secret.Env, secret.Key)]; !state.deleted {
+			secrets = append(secrets, secret)
+		}
+	}
+	tombstones := make([]Tombstone, 0, len(bundle.Tombstones))
+	for _, tombstone := range bundle.Tombstones {
+		if state := states[stateID(tombstone.Env, tombstone.Key)]; state.deleted {
+			tombstones = append(tombstones, tombstone)
+		}
+	}
+	bundle.Secrets = secrets
+	bundle.Tombstones = tombstones
+}
+
+func pruneTombstones(bundle *ProjectBundle, self string, recipients []string) {
+	kept := make([]Tombstone, 0, len(bundle.Tombstones))
+	for _, tombstone := range bundle.Tombstones {
+		tombstone.SeenBy = uniqueStrings(append(append([]string(nil), tombstone.SeenBy...), self))
+		seenBy := map[string]struct{}{}
+		for _, key := range tombstone.SeenBy {
+			seenBy[key] = struct{}{}
+		}
+		pending := false
+		for _, recipient := range recipients {
+			if _, ok := seenBy[recipient]; !ok {
+				pending = true
+				break
+			}
+		}
+		if pending {
+			kept = append(kept, tombstone)
+		}
+	}
+	bundle.Tombstones = kept
+}
+
+func bundlesEqual(a, b *ProjectBundle) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if !sameIncludes(a, b) {
+		return false
+	}
+	left := indexStates(a)
+	right := indexStates(b)
+	if len(left) != len(right) {
+		return false
+	}
+	for key, state := range left {
+		if !sameState(state, right[key]) {
+			return false
+		}
+		// Acknowledgements only change SeenBy, and they have to be pushed
+		// for a tombstone to ever be pruned.
+		if state.deleted && !slices.Equal(sortedStrings(state.tombstone.SeenBy), sortedStrings(right[key].tombstone.SeenBy)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedStrings(values []string) []string {
+	out := uniqueStrings(values)
+	sort.Strings(out)
+	return out
+}
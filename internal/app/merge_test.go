@@ -0,0 +1,261 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func testTime(day int) string {
+	return fmt.Sprintf("2026-01-%02dT00:00:00Z", day)
+}
+
+func testSecretAt(env, key, value string, day int) Secret {
+	return Secret{Env: env, Key: key, Value: value, CreatedAt: testTime(1), UpdatedAt: testTime(day)}
+}
+
+func testTombstoneAt(env, key string, day int) Tombstone {
+	return Tombstone{Env: env, Key: key, DeletedAt: testTime(day), MachineID: "m"}
+}
+
+// testBundle builds a project from Secrets and Tombstones in any order.
+func testBundle(items ...any) *ProjectBundle {
+	bundle := &ProjectBundle{Project: "app", Secrets: []Secret{}}
+	for _, item := range items {
+		switch item := item.(type) {
+		case Secret:
+			bundle.Secrets = append(bundle.Secrets, item)
+		case Tombstone:
+			bundle.Tombstones = append(bundle.Tombstones, item)
+		}
+	}
+	return bundle
+}
+
+// describeStates lists a bundle's merged view as "env/key=value" or
+// "env/key deleted", sorted.
+func describeStates(bundle *ProjectBundle) []string {
+	out := []string{}
+	for id, state := range indexStates(bundle) {
+		env, key := splitStateID(id)
+		name := env + "/" + key
+		if state.deleted {
+			out = append(out, name+" deleted")
+		} else {
+			out = append(out, name+"="+state.secret.Value)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestMergeBundles(t *testing.T) {
+	tests := []struct {
+		name                string
+		base, local, remote *ProjectBundle
+		want                []string
+		conflicts           []string
+	}{
+		{
+			name:   "new project from remote",
+			remote: testBundle(testSecretAt("", "A", "1", 1)),
+			want:   []string{"/A=1"},
+		},
+		{
+			name:   "added locally",
+			base:   testBundle(),
+			local:  testBundle(testSecretAt("", "A", "1", 2)),
+			remote: testBundle(),
+			want:   []string{"/A=1"},
+		},
+		{
+			name:   "edited remotely",
+			base:   testBundle(testSecretAt("", "A", "1", 1)),
+			local:  testBundle(testSecretAt("", "A", "1", 1)),
+			remote: testBundle(testSecretAt("", "A", "2", 2)),
+			want:   []string{"/A=2"},
+		},
+		{
+			name:   "same edit on both sides",
+			base:   testBundle(testSecretAt("", "A", "1", 1)),
+			local:  testBundle(testSecretAt("", "A", "2", 2)),
+			remote: testBundle(testSecretAt("", "A", "2", 3)),
+			want:   []string{"/A=2"},
+		},
+		{
+			name:      "conflict keeps the newer remote edit",
+			base:      testBundle(testSecretAt("", "A", "1", 1)),
+			local:     testBundle(testSecretAt("", "A", "local", 2)),
+			remote:    testBundle(testSecretAt("", "A", "remote", 3)),
+			want:      []string{"/A=remote"},
+			conflicts: []string{"/A:remote"},
+		},
+		{
+			name:      "conflict keeps the newer local edit",
+			base:      testBundle(testSecretAt("", "A", "1", 1)),
+			local:     testBundle(testSecretAt("", "A", "local", 3)),
+			remote:    testBundle(testSecretAt("", "A", "remote", 2)),
+			want:      []string{"/A=local"},
+			conflicts: []string{"/A:local"},
+		},
+		{
+			name:      "conflict added on both sides without a base",
+			local:     testBundle(testSecretAt("", "A", "local", 2)),
+			remote:    testBundle(testSecretAt("", "A", "remote", 2)),
+			want:      []string{"/A=local"},
+			conflicts: []string{"/A:local"},
+		},
+		{
+			name:   "deleted locally, untouched remotely",
+			base:   testBundle(testSecretAt("", "A", "1", 1)),
+			local:  testBundle(testTombstoneAt("", "A", 2)),
+			remote: testBundle(testSecretAt("", "A", "1", 1)),
+			want:   []string{"/A deleted"},
+		},
+		{
+			name:      "deleted locally, edited remotely later",
+			base:      testBundle(testSecretAt("", "A", "1", 1)),
+			local:     testBundle(testTombstoneAt("", "A", 2)),
+			remote:    testBundle(testSecretAt("", "A", "2", 3)),
+			want:      []string{"/A=2"},
+			conflicts: []string{"/A:remote"},
+		},
+		{
+			name:      "edited locally, deleted remotely later",
+			base:      testBundle(testSecretAt("", "A", "1", 1)),
+			local:     testBundle(testSecretAt("", "A", "2", 2)),
+			remote:    testBundle(testTombstoneAt("", "A", 3)),
+			want:      []string{"/A deleted"},
+			conflicts: []string{"/A:remote"},
+		},
+		{
+			name:   "removed from the remote without a tombstone",
+			base:   testBundle(testSecretAt("", "A", "1", 1)),
+			local:  testBundle(testSecretAt("", "A", "1", 1)),
+			remote: testBundle(),
+			want:   []string{},
+		},
+		{
+			name:   "each environment merges on its own",
+			base:   testBundle(testSecretAt("", "A", "1", 1), testSecretAt("prod", "A", "p1", 1)),
+			local:  testBundle(testSecretAt("", "A", "1", 1), testSecretAt("prod", "A", "p2", 2)),
+			remote: testBundle(testSecretAt("", "A", "2", 2), testSecretAt("prod", "A", "p1", 1)),
+			want:   []string{"/A=2", "prod/A=p2"},
+		},
+		{
+			name:      "a conflict in one environment leaves the other alone",
+			base:      testBundle(testSecretAt("", "A", "1", 1), testSecretAt("prod", "A", "p1", 1)),
+			local:     testBundle(testSecretAt("", "A", "1", 1), testSecretAt("prod", "A", "local", 2)),
+			remote:    testBundle(testSecretAt("", "A", "1", 1), testSecretAt("prod", "A", "remote", 3)),
+			want:      []string{"/A=1", "prod/A=remote"},
+			conflicts: []string{"prod/A:remote"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflicts := mergeBundles(tt.base, tt.local, tt.remote)
+			if got := describeStates(merged); !slices.Equal(got, tt.want) {
+				t.Errorf("merged = %q, want %q", got, tt.want)
+			}
+			got := []string{}
+			for _, conflict := range conflicts {
+				got = append(got, conflict.Env+"/"+conflict.Key+":"+conflict.Kept)
+			}
+			if tt.conflicts == nil {
+				tt.conflicts = []string{}
+			}
+			if !slices.Equal(got, tt.conflicts) {
+				t.Errorf("conflicts = %q, want %q", got, tt.conflicts)
+			}
+		})
+	}
+}
+
+// A tombstone hides secrets that are not newer than it; a secret set again
+// after the delete resurrects the key.
+func TestIndexStatesTombstones(t *testing.T) {
+	tests := []struct {
+		name   string
+		bundle *ProjectBundle
+		want   []string
+	}{
+		{
+			name:   "older secret stays deleted",
+			bundle: testBundle(testSecretAt("", "A", "1", 1), testTombstoneAt("", "A", 2)),
+			want:   []string{"/A deleted"},
+		},
+		{
+			name:   "secret at the same time stays deleted",
+			bundle: testBundle(testSecretAt("", "A", "1", 2), testTombstoneAt("", "A", 2)),
+			want:   []string{"/A deleted"},
+		},
+		{
+			name:   "newer secret resurrects the key",
+			bundle: testBundle(testTombstoneAt("", "A", 2), testSecretAt("", "A", "2", 3)),
+			want:   []string{"/A=2"},
+		},
+		{
+			name:   "tombstone only covers its environment",
+			bundle: testBundle(testSecretAt("", "A", "1", 1), testSecretAt("prod", "A", "p", 1), testTombstoneAt("prod", "A", 2)),
+			want:   []string{"/A=1", "prod/A deleted"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeStates(tt.bundle); !slices.Equal(got, tt.want) {
+				t.Errorf("states = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanChanges(t *testing.T) {
+	tests := []struct {
+		name                string
+		base, local, remote *ProjectBundle
+		want                []string
+	}{
+		{
+			name:   "in sync",
+			base:   testBundle(testSecretAt("", "A", "1", 1)),
+			local:  testBundle(testSecretAt("", "A", "1", 1)),
+			remote: testBundle(testSecretAt("", "A", "1", 1)),
+			want:   []string{},
+		},
+		{
+			name:   "pull and push",
+			base:   testBundle(testSecretAt("", "A", "1", 1), testSecretAt("", "B", "1", 1)),
+			local:  testBundle(testSecretAt("", "A", "1", 1), testSecretAt("", "B", "secret-b", 2)),
+			remote: testBundle(testSecretAt("", "A", "secret-a", 2), testSecretAt("", "B", "1", 1)),
+			want:   []string{"pull /A secret** ", "push /B secret** "},
+		},
+		{
+			name:   "conflict",
+			base:   testBundle(testSecretAt("prod", "A", "1", 1)),
+			local:  testBundle(testSecretAt("prod", "A", "local", 2)),
+			remote: testBundle(testSecretAt("prod", "A", "remote", 3)),
+			want:   []string{"conflict prod/A ****** keeping remote"},
+		},
+		{
+			name:   "delete on each side",
+			base:   testBundle(testSecretAt("", "A", "1", 1), testSecretAt("", "B", "1", 1)),
+			local:  testBundle(testTombstoneAt("", "A", 2), testSecretAt("", "B", "1", 1)),
+			remote: testBundle(testSecretAt("", "A", "1", 1), testTombstoneAt("", "B", 2)),
+			want:   []string{"delete /A  remote", "delete /B  local"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, conflicts := mergeBundles(tt.base, tt.local, tt.remote)
+			got := []string{}
+			for _, change := range planChanges("app", tt.local, tt.remote, result, conflicts) {
+				got = append(got, strings.Join([]string{change.Action, change.Env + "/" + change.Key, change.Value, change.Detail}, " "))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+type SecretMetadata struct {
+	Description string
+	Tags        []string
+	Source      string
+	ExpiresAt   string
+}
+
+func MetadataOf(secret Secret) SecretMetadata {
+	return SecretMetadata{
+		Description: secret.Description,
+		Tags:        secret.Tags,
+		Source:      secret.Source,
+		ExpiresAt:   secret.ExpiresAt,
+	}
+}
+
+// SetSecretMetadata replaces the metadata of a key in one layer. A change bumps
+// updated_at so sync carries it like a value edit, but does not add a version
+// to the value history.
+func SetSecretMetadata(bundle *ProjectBundle, env, key string, meta SecretMetadata) (bool, error) {
+	meta, err := NormalizeMetadata(meta)
+	if err != nil {
+		return false, err
+	}
+	for i := range bundle.Secrets {
+		secret := &bundle.Secrets[i]
+		if secret.Env != env || secret.Key != key {
+			continue
+		}
+		if sameMetadata(MetadataOf(*secret), meta) {
+			return false, nil
+		}
+		secret.Description = meta.Description
+		secret.Tags = meta.Tags
+		secret.Source = meta.Source
+		secret.ExpiresAt = meta.ExpiresAt
+		secret.UpdatedAt = nowRFC3339()
+		secret.UpdatedBy = ""
+		return true, nil
+	}
+	return false, fmt.Errorf("key %q not found in %s", key, LayerName(bundle.Project, env))
+}
+
+// ImportSecret upserts an imported secret into its environment's layer.
+// Metadata the import carries replaces what is stored; fields it leaves out
+// are kept.
+func ImportSecret(bundle *ProjectBundle, secret Secret) (bool, error) {
+	env := secret.Env
+	created := UpsertSecret(bundle, env, secret.Key, secret.Value, secret.Group)
+	existing, _ := GetSecret(bundle, env, secret.Key)
+	meta := MetadataOf(existing)
+	if secret.Description != "" {
+		meta.Description = secret.Description
+	}
+	if len(secret.Tags) > 0 {
+		meta.Tags = secret.Tags
+	}
+	if secret.Source != "" {
+		meta.Source = secret.Source
+	}
+	if secret.ExpiresAt != "" {
+		meta.ExpiresAt = secret.ExpiresAt
+	}
+	if _, err := SetSecretMetadata(bundle, env, secret.Key, meta); err != nil {
+		return created, fmt.Errorf("%s: %w", secret.Key, err)
+	}
+	if secret.Expand {
+		if _, err := SetSecretExpand(bundle, env, secret.Key, true); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// NormalizeMetadata trims the fields, splits tags given as one comma separated
+// entry and checks the source URL and expiry.
+func NormalizeMetadata(meta SecretMetadata) (SecretMetadata, error) {
+	meta.Description = strings.TrimSpace(meta.Description)
+	meta.Tags = ParseTags(strings.Join(meta.Tags, ","))
+	meta.Source = strings.TrimSpace(meta.Source)
+	if meta.Source != "" {
+		parsed, err := url.Parse(meta.Source)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return meta, fmt.Errorf("invalid source %q (use an http or https URL)", meta.Source)
+		}
+	}
+	expires, err := ParseExpiry(meta.ExpiresAt)
+	if err != nil {
+		return meta, err
+	}
+	meta.ExpiresAt = expires
+	return meta, nil
+}
+
+// ParseTags splits a comma separated list, dropping blanks and duplicates.
+func ParseTags(raw string) []string {
+	var tags []string
+	for _, tag := range strings.Split(raw, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ParseExpiry accepts a date (taken as midnight UTC) or an RFC 3339 time and
+// returns it in the store's RFC 3339 UTC form.
+func ParseExpiry(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	return "", fmt.Errorf("invalid expiry %q (use YYYY-MM-DD or RFC 3339)", raw)
+}
+
+// ExpiryLabel reads "expires 2026-12-31" or "expired 2026-12-31", or "" when
+// the secret has no expiry.
+func ExpiryLabel(expiresAt string, now time.Time) string {
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return ""
+	}
+	if !now.Before(t) {
+		return "expired " + t.Format("2006-01-02")
+	}
+	return "expires " + t.Format("2006-01-02")
+}
+
+func sameMetadata(a, b SecretMetadata) bool {
+	return a.Description == b.Description && a.Source == b.Source && a.ExpiresAt == b.ExpiresAt && slices.Equal(a.Tags, b.Tags)
+}
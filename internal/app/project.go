@@ -1,7 +1,6 @@
 package app
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -9,7 +8,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
-	"time"
+
+	"filippo.io/age"
 )
 
 var markerFiles = []string{
@@ -34,7 +34,14 @@ var prefixGroups = map[string]string{
 	"POSTGRES_":    "Database",
 }
 
-var invalidProjectName = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+var (
+	invalidProjectName = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+	validEnvName       = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+// The base layer is stored without an environment name; "base" is accepted
+// on the command line to address it explicitly.
+const baseEnvName = "base"
 
 type ProjectSummary struct {
 	Name  string
@@ -122,32 +129,37 @@ func (a *App) LoadProject(name, path string) (*ProjectBundle, error) {
 	if err != nil {
 		return nil, err
 	}
-	bundle := &ProjectBundle{
-		Project: name,
-		Path:    normalizePath(path),
-		Secrets: []Secret{},
-	}
 	filePath := a.projectFilePath(name)
 	b, err := os.ReadFile(filePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return bundle, nil
+			return &ProjectBundle{
+				Project: name,
+				Path:    normalizePath(path),
+				Secrets: []Secret{},
+			}, nil
 		}
 		return nil, fmt.Errorf("read project file: %w", err)
 	}
-	plain, err := decryptJSON(string(b), identity)
+	bundle, signer, err := decodeBundle(string(b), identity)
 	if err != nil {
-		return nil, fmt.Errorf("decrypt project %q: %w", name, err)
+		return nil, fmt.Errorf("project %q: %w", name, err)
 	}
-	if err := json.Unmarshal(plain, bundle); err != nil {
-		return nil, fmt.Errorf("decode project %q: %w", name, err)
+	if signer != nil {
+		if err := a.checkSigner(signer); err != nil {
+			return nil, fmt.Errorf("project %q: %w", name, err)
+		}
+		if err := checkProject(bundle, signer, name); err != nil {
+			return nil, fmt.Errorf("project %q: %w", name, err)
+		}
 	}
-	if bundle.Secrets == nil {
-		bundle.Secrets = []Secret{}
+	if bundle.Project == "" {
+		bundle.Project = name
 	}
 	if bundle.Path == "" {
 		bundle.Path = normalizePath(path)
 	}
+	normalizeBundle(bundle)
 	return bundle, nil
 }
 
@@ -161,15 +173,21 @@ func (a *App) SaveProject(bundle *ProjectBundle) error {
 	}
 	bundle.Project = sanitizeProjectName(bundle.Project)
 	bundle.Path = normalizePath(bundle.Path)
+	for i := range bundle.Tombstones {
+		if bundle.Tombstones[i].MachineID == "" {
+			bundle.Tombstones[i].MachineID = a.config.Machine.ID
+		}
+	}
+	for i := range bundle.Secrets {
+		if bundle.Secrets[i].UpdatedBy == "" {
+			bundle.Secrets[i].UpdatedBy = a.config.Machine.ID
+		}
+	}
 
 	recipients := uniqueStrings(append(a.config.Recipients, identity.Recipient().String()))
 	a.config.Recipients = recipients
 
-	data, err := json.MarshalIndent(bundle, "", "  ")
-	if err != nil {
-		return fmt.Errorf("encode project: %w", err)
-	}
-	ciphertext, err := encryptJSON(data, recipients)
+	ciphertext, err := a.encodeBundle(bundle, recipients, identity)
 	if err != nil {
 		return err
 	}
@@ -178,9 +196,78 @@ func (a *App) SaveProject(bundle *ProjectBundle) error {
 		return fmt.Errorf("write project file: %w", err)
 	}
 	a.registerProject(bundle.Project, bundle.Path)
+	a.markProjectChanged(bundle.Project)
 	return a.SaveConfig()
 }
 
+func decodeBundle(ciphertext string, identity *age.X25519Identity) (*ProjectBundle, *Signer, error) {
+	plain, err := decryptJSON(ciphertext, identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle, signer, err := openBundle(plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bundle.Secrets == nil {
+		bundle.Secrets = []Secret{}
+	}
+	return bundle, signer, nil
+}
+
+func (a *App) encodeBundle(bundle *ProjectBundle, recipients []string, identity *age.X25519Identity) (string, error) {
+	data, err := signBundle(bundle, a.config.Machine.ID, identity)
+	if err != nil {
+		return "", err
+	}
+	return encryptJSON(data, recipients)
+}
+
+func (a *App) baseFilePath(name string) string {
+	return filepath.Join(a.BaseDir, sanitizeProjectName(name)+".json.age")
+}
+
+func (a *App) loadBase(name string, identity *age.X25519Identity) *ProjectBundle {
+	b, err := os.ReadFile(a.baseFilePath(name))
+	if err != nil {
+		return nil
+	}
+	bundle, _, err := decodeBundle(string(b), identity)
+	if err != nil {
+		return nil
+	}
+	return bundle
+}
+
+func (a *App) saveBase(bundle *ProjectBundle, identity *age.X25519Identity) error {
+	if err := os.MkdirAll(a.BaseDir, 0o700); err != nil {
+		return fmt.Errorf("create base directory: %w", err)
+	}
+	ciphertext, err := a.encodeBundle(bundle, []string{identity.Recipient().String()}, identity)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.baseFilePath(bundle.Project), []byte(ciphertext), 0o600); err != nil {
+		return fmt.Errorf("write base snapshot: %w", err)
+	}
+	return nil
+}
+
+func (a *App) localProjectNames() ([]string, error) {
+	entries, err := os.ReadDir(a.StoreDir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json.age") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json.age"))
+	}
+	return names, nil
+}
+
 func (a *App) ListProjects() ([]ProjectSummary, error) {
 	if _, err := a.LoadConfig(); err != nil {
 		return nil, err
@@ -222,22 +309,73 @@ func detectGroup(key string) string {
 	return "General"
 }
 
-func UpsertSecret(bundle *ProjectBundle, key, value, group string) (created bool) {
+func NormalizeEnv(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == baseEnvName {
+		return "", nil
+	}
+	if !validEnvName.MatchString(name) {
+		return "", fmt.Errorf("invalid environment %q (use letters, digits, - and _)", name)
+	}
+	return name, nil
+}
+
+func EnvLabel(env string) string {
+	if env == "" {
+		return baseEnvName
+	}
+	return env
+}
+
+// EnvKey names a secret in messages and sync plans; base keys keep their
+// plain name.
+func EnvKey(env, key string) string {
+	if env == "" {
+		return key
+	}
+	return env + "/" + key
+}
+
+// Environments lists the named environments of a project; the base layer is
+// always present and not included.
+func Environments(bundle *ProjectBundle) []string {
+	envs := []string{}
+	for _, secret := range bundle.Secrets {
+		if secret.Env != "" {
+			envs = append(envs, secret.Env)
+		}
+	}
+	envs = uniqueStrings(envs)
+	sort.Strings(envs)
+	return envs
+}
+
+func UpsertSecret(bundle *ProjectBundle, env, key, value, group string) (created bool) {
 	now := nowRFC3339()
+	clearTombstone(bundle, env, key)
 	for i := range bundle.Secrets {
-		if bundle.Secrets[i].Key == key {
+		if bundle.Secrets[i].Env == env && bundle.Secrets[i].Key == key {
+			if bundle.Secrets[i].Value != value {
+				recordVersion(&bundle.Secrets[i])
+			}
 			bundle.Secrets[i].Value = value
 			if group != "" {
 				bundle.Secrets[i].Group = group
 			}
 			bundle.Secrets[i].UpdatedAt = now
+			bundle.Secrets[i].UpdatedBy = ""
 			return false
 		}
 	}
 	if group == "" {
-		group = detectGroup(key)
+		if inherited, ok := GetSecret(bundle, "", key); ok && env != "" {
+			group = inherited.Group
+		} else {
+			group = detectGroup(key)
+		}
 	}
 	bundle.Secrets = append(bundle.Secrets, Secret{
+		Env:       env,
 		Key:       key,
 		Value:     value,
 		Group:     group,
@@ -247,19 +385,32 @@ func UpsertSecret(bundle *ProjectBundle, key, value, group string) (created bool
 	return true
 }
 
-func RemoveSecret(bundle *ProjectBundle, key string) bool {
+func RemoveSecret(bundle *ProjectBundle, env, key string) bool {
 	for i := range bundle.Secrets {
-		if bundle.Secrets[i].Key == key {
+		if bundle.Secrets[i].Env == env && bundle.Secrets[i].Key == key {
 			bundle.Secrets = append(bundle.Secrets[:i], bundle.Secrets[i+1:]...)
+			clearTombstone(bundle, env, key)
+			bundle.Tombstones = append(bundle.Tombstones, Tombstone{Env: env, Key: key, DeletedAt: nowRFC3339()})
 			return true
 		}
 	}
 	return false
 }
 
-func GetSecret(bundle *ProjectBundle, key string) (Secret, bool) {
+func clearTombstone(bundle *ProjectBundle, env, key string) {
+	for i := range bundle.Tombstones {
+		if bundle.Tombstones[i].Env == env && bundle.Tombstones[i].Key == key {
+			bundle.Tombstones = append(bundle.Tombstones[:i], bundle.Tombstones[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetSecret looks in a single layer; App.ResolveSecrets gives the view with
+// the base layer and included bundles applied.
+func GetSecret(bundle *ProjectBundle, env, key string) (Secret, bool) {
 	for _, secret := range bundle.Secrets {
-		if secret.Key == key {
+		if secret.Env == env && secret.Key == key {
 			return secret, true
 		}
 	}
@@ -275,17 +426,3 @@ func MaskValue(value string) string {
 	}
 	return value[:6] + strings.Repeat("*", len(value)-6)
 }
-
-func latestUpdate(bundle *ProjectBundle) time.Time {
-	var latest time.Time
-	for _, secret := range bundle.Secrets {
-		t, err := time.Parse(time.RFC3339, secret.UpdatedAt)
-		if err != nil {
-			continue
-		}
-		if t.After(latest) {
-			latest = t
-		}
-	}
-	return latest
-}
@@ -0,0 +1,29 @@
+package app
+
+import "testing"
+
+func TestNormalizeEnv(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", "", true},
+		{"base", "", true},
+		{" Base ", "", true},
+		{"prod", "prod", true},
+		{"Staging", "staging", true},
+		{"eu-west_1", "eu-west_1", true},
+		{"-prod", "", false},
+		{"pro d", "", false},
+		{"prod/eu", "", false},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeEnv(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("NormalizeEnv(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+	if EnvLabel("") != "base" || EnvLabel("prod") != "prod" {
+		t.Errorf("EnvLabel maps the base layer to %q", EnvLabel(""))
+	}
+}
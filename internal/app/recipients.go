@@ -0,0 +1,176 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+)
+
+func parseRecipients(content string) []string {
+	out := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := age.ParseX25519Recipient(line); err != nil {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func (a *App) isTrustedRecipient(key string) bool {
+	for _, recipient := range a.config.Recipients {
+		if recipient == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) isDeniedRecipient(key string) bool {
+	for _, denied := range a.config.Denied {
+		if denied == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) isPendingRecipient(key string) bool {
+	for _, pending := range a.config.Pending {
+		if pending.PublicKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeRemoteRecipients holds every key this machine has not seen before as
+// pending, including on link: being in recipients.txt only means some writer
+// trusts the key, and that trust has to be granted again here.
+func (a *App) mergeRemoteRecipients(remote []string) []string {
+	added := []string{}
+	for _, key := range remote {
+		if a.isTrustedRecipient(key) || a.isDeniedRecipient(key) || a.isPendingRecipient(key) {
+			continue
+		}
+		a.config.Pending = append(a.config.Pending, PendingRecipient{PublicKey: key, FirstSeenAt: nowRFC3339()})
+		added = append(added, key)
+	}
+	return added
+}
+
+// publishedRecipients is the trusted set only. Pending keys stay local until
+// approved, so a key nobody vouched for is never passed on as trusted.
+func (a *App) publishedRecipients() []string {
+	out := uniqueStrings(append([]string(nil), a.config.Recipients...))
+	sort.Strings(out)
+	return out
+}
+
+func (a *App) PendingRecipients() ([]PendingRecipient, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return nil, err
+	}
+	return append([]PendingRecipient(nil), a.config.Pending...), nil
+}
+
+func (a *App) PendingSigners() ([]Signer, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return nil, err
+	}
+	return append([]Signer(nil), a.config.PendingSigners...), nil
+}
+
+// findPending matches the pending keys `veil machines` lists, by key prefix or
+// by the ID shown next to the key. The ID is looked up from the key and never
+// the other way round, so it can only name a key the user has seen listed.
+func (a *App) findPending(query string) (int, int, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return -1, -1, errors.New("missing public key or machine id")
+	}
+	owners := map[string]string{}
+	for _, record := range a.config.Machines {
+		owners[record.PublicKey] = record.ID
+	}
+	recipient, signer := -1, -1
+	for i, pending := range a.config.Pending {
+		owner := owners[pending.PublicKey]
+		if !strings.HasPrefix(pending.PublicKey, query) && (owner == "" || owner != query) {
+			continue
+		}
+		if recipient != -1 {
+			return -1, -1, fmt.Errorf("%q matches more than one pending key", query)
+		}
+		recipient = i
+	}
+	for i, pending := range a.config.PendingSigners {
+		if !strings.HasPrefix(pending.PublicKey, query) && pending.MachineID != query {
+			continue
+		}
+		if signer != -1 {
+			return -1, -1, fmt.Errorf("%q matches more than one pending key", query)
+		}
+		signer = i
+	}
+	if recipient == -1 && signer == -1 {
+		return -1, -1, fmt.Errorf("no pending key matches %q", query)
+	}
+	return recipient, signer, nil
+}
+
+func (a *App) ApproveMachine(query string) (string, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return "", err
+	}
+	recipient, signer, err := a.findPending(query)
+	if err != nil {
+		return "", err
+	}
+	approved := []string{}
+	if signer >= 0 {
+		trusted := a.config.PendingSigners[signer]
+		trusted.FirstSeenAt = ""
+		approved = append(approved, "signing key of "+trusted.MachineID)
+		a.config.PendingSigners = append(a.config.PendingSigners[:signer], a.config.PendingSigners[signer+1:]...)
+		a.config.Signers = append(a.config.Signers, trusted)
+	}
+	if recipient >= 0 {
+		key := a.config.Pending[recipient].PublicKey
+		approved = append(approved, key)
+		a.config.Pending = append(a.config.Pending[:recipient], a.config.Pending[recipient+1:]...)
+		a.config.Recipients = uniqueStrings(append(a.config.Recipients, key))
+	}
+	return strings.Join(approved, " and "), a.SaveConfig()
+}
+
+func (a *App) DenyMachine(query string) (string, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return "", err
+	}
+	recipient, signer, err := a.findPending(query)
+	if err != nil {
+		return "", err
+	}
+	denied := []string{}
+	if signer >= 0 {
+		rejected := a.config.PendingSigners[signer]
+		denied = append(denied, "signing key of "+rejected.MachineID)
+		a.config.PendingSigners = append(a.config.PendingSigners[:signer], a.config.PendingSigners[signer+1:]...)
+		a.config.Denied = uniqueStrings(append(a.config.Denied, rejected.PublicKey))
+	}
+	if recipient >= 0 {
+		key := a.config.Pending[recipient].PublicKey
+		denied = append(denied, key)
+		a.config.Pending = append(a.config.Pending[:recipient], a.config.Pending[recipient+1:]...)
+		a.config.Denied = uniqueStrings(append(a.config.Denied, key))
+	}
+	return strings.Join(denied, " and "), a.SaveConfig()
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const recoveryVersion = "v1"
+
+type RecoverySheet struct {
+	MachineID   string
+	MachineName string
+	AddedAt     string
+	PublicKey   string
+	Identity    string
+	Remote      string
+}
+
+func (s RecoverySheet) checksum() string {
+	sum := sha256.Sum256([]byte(s.MachineID + "\n" + s.Identity + "\n"))
+	return hex.EncodeToString(sum[:4])
+}
+
+func (s RecoverySheet) Payload() string {
+	lines := []string{
+		"veil-recovery: " + recoveryVersion,
+		"machine-id: " + s.MachineID,
+		"machine-name: " + s.MachineName,
+		"added-at: " + s.AddedAt,
+		"public-key: " + s.PublicKey,
+		"identity: " + s.Identity,
+	}
+	if s.Remote != "" {
+		lines = append(lines, "remote: "+s.Remote)
+	}
+	lines = append(lines, "checksum: "+s.checksum())
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (s RecoverySheet) Text() string {
+	var b strings.Builder
+	b.WriteString("# VEIL RECOVERY SHEET\n")
+	b.WriteString("# Anyone holding this sheet can decrypt every secret shared with this machine.\n")
+	b.WriteString("# Print it, store it offline, and destroy it after `veil rotate-key`.\n")
+	b.WriteString("#\n")
+	b.WriteString(s.Payload())
+	b.WriteString("#\n")
+	b.WriteString("# Restore with: veil recovery restore sheet.txt\n")
+	return b.String()
+}
+
+func ParseRecoverySheet(content string) (RecoverySheet, error) {
+	var sheet RecoverySheet
+	fields := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	if fields["veil-recovery"] != recoveryVersion {
+		return sheet, errors.New("not a veil recovery sheet (missing `veil-recovery: v1` line)")
+	}
+	sheet = RecoverySheet{
+		MachineID:   fields["machine-id"],
+		MachineName: fields["machine-name"],
+		AddedAt:     fields["added-at"],
+		PublicKey:   fields["public-key"],
+		Identity:    strings.ToUpper(fields["identity"]),
+		Remote:      fields["remote"],
+	}
+	if sheet.MachineID == "" || sheet.Identity == "" {
+		return sheet, errors.New("recovery sheet is missing machine-id or identity")
+	}
+	if want := strings.ToLower(fields["checksum"]); want != "" && want != sheet.checksum() {
+		return sheet, errors.New("recovery sheet checksum does not match (check for typos in machine-id and identity)")
+	}
+	id, err := age.ParseX25519Identity(sheet.Identity)
+	if err != nil {
+		return sheet, fmt.Errorf("recovery sheet identity: %w", err)
+	}
+	if sheet.PublicKey != "" && sheet.PublicKey != id.Recipient().String() {
+		return sheet, errors.New("recovery sheet public key does not match its identity")
+	}
+	sheet.PublicKey = id.Recipient().String()
+	return sheet, nil
+}
+
+func (a *App) ExportRecovery() (RecoverySheet, error) {
+	identity, err := a.LoadIdentity()
+	if err != nil {
+		return RecoverySheet{}, err
+	}
+	return RecoverySheet{
+		MachineID:   a.config.Machine.ID,
+		MachineName: a.config.Machine.Name,
+		AddedAt:     a.config.Machine.AddedAt,
+		PublicKey:   identity.Recipient().String(),
+		Identity:    identity.String(),
+		Remote:      a.config.Remote.String(),
+	}, nil
+}
+
+func (a *App) RestoreRecovery(sheet RecoverySheet, keyStorage string) error {
+	if _, err := a.LoadConfig(); err != nil {
+		return err
+	}
+	if keyStorage == "" {
+		keyStorage = a.config.KeyStorage
+	}
+	if keyStorage == "" {
+		keyStorage = keyStorageFile
+	}
+	if !validKeyStorage(keyStorage) {
+		return fmt.Errorf("invalid key storage %q (use %s)", keyStorage, strings.Join(keyStorageKinds, ", "))
+	}
+	id, err := age.ParseX25519Identity(sheet.Identity)
+	if err != nil {
+		return fmt.Errorf("recovery sheet identity: %w", err)
+	}
+	if a.IsInitialized() {
+		if a.config.Machine.ID != sheet.MachineID {
+			return fmt.Errorf("this Veil home belongs to machine %s, not %s (restore into an empty VEIL_HOME)", a.config.Machine.ID, sheet.MachineID)
+		}
+		if a.config.Machine.PublicKey != id.Recipient().String() {
+			return errors.New("recovery sheet predates the last `veil rotate-key` on this machine")
+		}
+	} else {
+		name := sheet.MachineName
+		if name == "" {
+			name = "veil-machine"
+		}
+		addedAt := sheet.AddedAt
+		if addedAt == "" {
+			addedAt = nowRFC3339()
+		}
+		a.config.Machine = MachineConfig{
+			ID:        sheet.MachineID,
+			Name:      name,
+			PublicKey: id.Recipient().String(),
+			AddedAt:   addedAt,
+		}
+		a.config.Recipients = uniqueStrings(append(a.config.Recipients, id.Recipient().String()))
+	}
+	a.config.Machine.SigningKey = signingPublicKey(id)
+	a.config.KeyStorage = keyStorage
+	a.identity = nil
+	if err := a.saveIdentity(id); err != nil {
+		return err
+	}
+	if _, err := a.readIdentity(); err != nil {
+		return fmt.Errorf("verify restored identity: %w", err)
+	}
+	a.identity = id
+	return a.SaveConfig()
+}
@@ -0,0 +1,455 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	remoteGist   = "gist"
+	remoteDir    = "dir"
+	remoteGit    = "git"
+	remoteS3     = "s3"
+	remoteWebDAV = "webdav"
+)
+
+// remoteTimeout bounds each request to the S3 and WebDAV backends, so a
+// stalled server fails the sync instead of hanging it.
+const remoteTimeout = 30 * time.Second
+
+var (
+	remoteKinds      = []string{remoteGist, remoteDir, remoteGit, remoteS3, remoteWebDAV}
+	errRemoteMissing = errors.New("not found on remote")
+	errRemoteChanged = errors.New("remote changed during sync")
+)
+
+type SyncBackend interface {
+	Type() string
+	List() ([]string, error)
+	Fetch(name string) (string, error)
+	Put(files map[string]string) error
+	Recipients() ([]string, error)
+}
+
+func (r RemoteConfig) Linked() bool {
+	return r.Type != ""
+}
+
+func (r RemoteConfig) String() string {
+	switch r.Type {
+	case "":
+		return ""
+	case remoteGist:
+		return "gist " + r.ID
+	case remoteS3:
+		return "s3 " + r.Bucket + "/" + r.Prefix
+	case remoteWebDAV:
+		return "webdav " + r.URL
+	default:
+		return r.Type + " " + r.Path
+	}
+}
+
+func validRemoteType(kind string) bool {
+	for _, known := range remoteKinds {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) openBackend(token string) (SyncBackend, error) {
+	if !a.config.Remote.Linked() {
+		return nil, errors.New("no remote linked (run `veil link`)")
+	}
+	return a.backendFor(a.config.Remote, token)
+}
+
+func (a *App) backendFor(remote RemoteConfig, token string) (SyncBackend, error) {
+	switch remote.Type {
+	case remoteGist:
+		if strings.TrimSpace(token) == "" {
+			var err error
+			token, err = a.LoadGitHubToken()
+			if err != nil {
+				return nil, err
+			}
+		}
+		gh, err := a.github(token)
+		if err != nil {
+			return nil, err
+		}
+		return &gistBackend{gh: gh, id: remote.ID}, nil
+	case remoteDir, remoteGit:
+		if remote.Path == "" {
+			return nil, fmt.Errorf("%s remote has no path (run `veil link --backend %s --path DIR`)", remote.Type, remote.Type)
+		}
+		return &dirBackend{path: remote.Path, git: remote.Type == remoteGit, machine: a.config.Machine.Name}, nil
+	case remoteS3:
+		return a.newS3Backend(remote)
+	case remoteWebDAV:
+		return a.newWebDAVBackend(remote)
+	default:
+		return nil, fmt.Errorf("unknown remote type %q (use %s)", remote.Type, strings.Join(remoteKinds, ", "))
+	}
+}
+
+func newRemoteClient() *http.Client {
+	return &http.Client{Timeout: remoteTimeout}
+}
+
+func fetchOptional(backend SyncBackend, name string) (string, error) {
+	content, err := backend.Fetch(name)
+	if errors.Is(err, errRemoteMissing) {
+		return "", nil
+	}
+	return content, err
+}
+
+func remoteRecipients(backend SyncBackend) ([]string, error) {
+	content, err := fetchOptional(backend, recipientsFileName)
+	if err != nil {
+		return nil, err
+	}
+	return parseRecipients(content), nil
+}
+
+// dirLockName guards the check-and-write in Put against another machine
+// syncing into the same directory; a lock older than dirLockStale is left
+// over from a crash and is taken over.
+const (
+	dirLockName  = ".veil-lock"
+	dirLockStale = 2 * time.Minute
+)
+
+type dirBackend struct {
+	path     string
+	git      bool
+	machine  string
+	readOnly bool
+	pulled   bool
+	revision string
+	head     string
+	// ref is the fetched upstream commit a read-only git backend reads from
+	// in place of the working tree, with the files it holds.
+	ref      string
+	refFiles map[string]bool
+}
+
+func (b *dirBackend) Type() string {
+	if b.git {
+		return remoteGit
+	}
+	return remoteDir
+}
+
+func (b *dirBackend) open() error {
+	if b.pulled {
+		return nil
+	}
+	b.pulled = true
+	if err := os.MkdirAll(b.path, 0o700); err != nil {
+		return fmt.Errorf("create remote directory: %w", err)
+	}
+	if b.git {
+		if _, err := b.runGit("rev-parse", "--git-dir"); err != nil {
+			return fmt.Errorf("%s is not a git repository: %w", b.path, err)
+		}
+		if b.readOnly {
+			return b.fetchUpstream()
+		}
+		if err := b.pull(); err != nil {
+			return err
+		}
+		b.head = b.gitHead()
+	}
+	revision, err := b.dirRevision()
+	if err != nil {
+		return err
+	}
+	b.revision = revision
+	return nil
+}
+
+func (b *dirBackend) pull() error {
+	remote := b.gitRemote()
+	if remote == "" {
+		return nil
+	}
+	if _, err := b.runGit("fetch", "--quiet", remote); err != nil {
+		return fmt.Errorf("git fetch: %w", err)
+	}
+	if _, err := b.runGit("rev-parse", "--verify", "--quiet", "@{u}"); err != nil {
+		return nil
+	}
+	if _, err := b.runGit("merge", "--ff-only", "--quiet", "@{u}"); err != nil {
+		return fmt.Errorf("git merge: %w", err)
+	}
+	return nil
+}
+
+// fetchUpstream is the read-only counterpart of pull for dry runs: it fetches
+// and reads the upstream commit directly, leaving the branch and working tree
+// alone.
+func (b *dirBackend) fetchUpstream() error {
+	remote := b.gitRemote()
+	if remote == "" {
+		return nil
+	}
+	if _, err := b.runGit("fetch", "--quiet", remote); err != nil {
+		return fmt.Errorf("git fetch: %w", err)
+	}
+	upstream, err := b.runGit("rev-parse", "--verify", "--quiet", "@{u}")
+	if err != nil {
+		return nil
+	}
+	tree, err := b.runGit("ls-tree", "--name-only", strings.TrimSpace(upstream))
+	if err != nil {
+		return fmt.Errorf("git ls-tree: %w", err)
+	}
+	b.ref = strings.TrimSpace(upstream)
+	b.refFiles = map[string]bool{}
+	for _, name := range strings.Split(tree, "\n") {
+		if name != "" && !strings.HasPrefix(name, ".") {
+			b.refFiles[name] = true
+		}
+	}
+	return nil
+}
+
+// dirRevision hashes every file sync reads, so Put can tell whether anything
+// was written since.
+func (b *dirBackend) dirRevision() (string, error) {
+	entries, err := os.ReadDir(b.path)
+	if err != nil {
+		return "", fmt.Errorf("read remote directory: %w", err)
+	}
+	hash := sha256.New()
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(b.path, entry.Name()))
+		if err != nil {
+			return "", fmt.Errorf("read remote file: %w", err)
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", entry.Name(), len(content))
+		hash.Write(content)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (b *dirBackend) gitHead() string {
+	head, err := b.runGit("rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(head)
+}
+
+func (b *dirBackend) lock() (func(), error) {
+	path := filepath.Join(b.path, dirLockName)
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			fmt.Fprintln(f, b.machine)
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("lock remote directory: %w", err)
+		}
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < dirLockStale {
+			return nil, fmt.Errorf("%s is locked by another sync: %w", b.path, errRemoteChanged)
+		}
+		os.Remove(path)
+	}
+	return nil, fmt.Errorf("%s is locked by another sync: %w", b.path, errRemoteChanged)
+}
+
+func (b *dirBackend) List() ([]string, error) {
+	if err := b.open(); err != nil {
+		return nil, err
+	}
+	if b.ref != "" {
+		names := make([]string, 0, len(b.refFiles))
+		for name := range b.refFiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+	entries, err := os.ReadDir(b.path)
+	if err != nil {
+		return nil, fmt.Errorf("read remote directory: %w", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *dirBackend) Fetch(name string) (string, error) {
+	if err := b.open(); err != nil {
+		return "", err
+	}
+	if b.ref != "" {
+		if !b.refFiles[filepath.Base(name)] {
+			return "", fmt.Errorf("%s: %w", name, errRemoteMissing)
+		}
+		content, err := b.runGit("cat-file", "blob", b.ref+":"+filepath.Base(name))
+		if err != nil {
+			return "", fmt.Errorf("read remote file: %w", err)
+		}
+		return content, nil
+	}
+	content, err := os.ReadFile(filepath.Join(b.path, filepath.Base(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s: %w", name, errRemoteMissing)
+	}
+	if err != nil {
+		return "", fmt.Errorf("read remote file: %w", err)
+	}
+	return string(content), nil
+}
+
+// Put only writes when the directory, and for git the checked out commit,
+// are still what open saw; otherwise it returns errRemoteChanged and the sync
+// re-reads. A push rejected because the upstream moved is reported the same
+// way, after dropping the local commit so the next attempt can fast-forward.
+func (b *dirBackend) Put(files map[string]string) error {
+	if b.readOnly {
+		return errors.New("dry run cannot write to the remote")
+	}
+	if err := b.open(); err != nil {
+		return err
+	}
+	unlock, err := b.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if b.git && b.gitHead() != b.head {
+		return fmt.Errorf("%s: %w", b.path, errRemoteChanged)
+	}
+	revision, err := b.dirRevision()
+	if err != nil {
+		return err
+	}
+	if revision != b.revision {
+		return fmt.Errorf("%s: %w", b.path, errRemoteChanged)
+	}
+	names := make([]string, 0, len(files))
+	for name, content := range files {
+		name = filepath.Base(name)
+		if err := writeFileAtomic(filepath.Join(b.path, name), []byte(content)); err != nil {
+			return fmt.Errorf("write remote file: %w", err)
+		}
+		names = append(names, name)
+	}
+	if !b.git || len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	if _, err := b.runGit(append([]string{"add", "--"}, names...)...); err != nil {
+		return fmt.Errorf("git add: %w", err)
+	}
+	if _, err := b.runGit("diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	if _, err := b.runGit("commit", "--quiet", "-m", "veil: sync from "+b.machine); err != nil {
+		return fmt.Errorf("git commit: %w", err)
+	}
+	remote := b.gitRemote()
+	if remote == "" {
+		return nil
+	}
+	if _, err := b.runGit("push", "--quiet", "--set-upstream", remote, "HEAD"); err != nil {
+		if !pushRejected(err) {
+			return fmt.Errorf("git push: %w", err)
+		}
+		if b.head != "" {
+			if _, resetErr := b.runGit("reset", "--hard", "--quiet", b.head); resetErr != nil {
+				return fmt.Errorf("git push: %w (and resetting the local commit failed: %v)", err, resetErr)
+			}
+		}
+		return fmt.Errorf("git push: %w", errRemoteChanged)
+	}
+	return nil
+}
+
+func pushRejected(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "[rejected]") || strings.Contains(msg, "non-fast-forward") || strings.Contains(msg, "fetch first")
+}
+
+func (b *dirBackend) Recipients() ([]string, error) {
+	return remoteRecipients(b)
+}
+
+func (b *dirBackend) gitRemote() string {
+	if branch, err := b.runGit("symbolic-ref", "--short", "HEAD"); err == nil {
+		if remote, err := b.runGit("config", "branch."+strings.TrimSpace(branch)+".remote"); err == nil {
+			return strings.TrimSpace(remote)
+		}
+	}
+	remotes, err := b.runGit("remote")
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(remotes)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func (b *dirBackend) runGit(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", b.path}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
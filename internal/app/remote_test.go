@@ -0,0 +1,151 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// Two machines read the same directory; the second to write sees through
+// dirRevision that the first already did.
+func TestDirBackendDetectsConcurrentWrite(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.json.age"), []byte("v1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	first := &dirBackend{path: dir, machine: "first"}
+	second := &dirBackend{path: dir, machine: "second"}
+	for _, backend := range []*dirBackend{first, second} {
+		if _, err := backend.List(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := first.Put(map[string]string{"app.json.age": "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Put(map[string]string{"app.json.age": "second"}); !errors.Is(err, errRemoteChanged) {
+		t.Fatalf("Put after a concurrent write = %v, want errRemoteChanged", err)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "app.json.age")); got != "first" {
+		t.Fatalf("app.json.age = %q, want the first write", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, dirLockName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("lock left behind after Put: %v", err)
+	}
+
+	// A fresh read picks up the write and may then replace it.
+	again := &dirBackend{path: dir, machine: "second"}
+	if content, err := again.Fetch("app.json.age"); err != nil || content != "first" {
+		t.Fatalf("Fetch = %q, %v", content, err)
+	}
+	if err := again.Put(map[string]string{"app.json.age": "second"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirBackendLock(t *testing.T) {
+	dir := t.TempDir()
+	lock := filepath.Join(dir, dirLockName)
+	if err := os.WriteFile(lock, []byte("other\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	backend := &dirBackend{path: dir, machine: "alice"}
+	if err := backend.Put(map[string]string{"app.json.age": "alice"}); !errors.Is(err, errRemoteChanged) {
+		t.Fatalf("Put under a held lock = %v, want errRemoteChanged", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.json.age")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Put wrote through a held lock: %v", err)
+	}
+
+	stale := time.Now().Add(-2 * dirLockStale)
+	if err := os.Chtimes(lock, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Put(map[string]string{"app.json.age": "alice"}); err != nil {
+		t.Fatalf("Put over a stale lock = %v", err)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "app.json.age")); got != "alice" {
+		t.Fatalf("app.json.age = %q", got)
+	}
+	if _, err := os.Stat(lock); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stale lock not released: %v", err)
+	}
+}
+
+func runTestGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// A push rejected because another checkout pushed first drops the local
+// commit and reports errRemoteChanged; the next sync fast-forwards and pushes.
+func TestGitBackendRejectedPush(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "veil")
+	t.Setenv("GIT_AUTHOR_EMAIL", "veil@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "veil")
+	t.Setenv("GIT_COMMITTER_EMAIL", "veil@example.com")
+
+	root := t.TempDir()
+	bare := filepath.Join(root, "remote.git")
+	runTestGit(t, root, "init", "--quiet", "--bare", bare)
+	alicePath, bobPath := filepath.Join(root, "alice"), filepath.Join(root, "bob")
+	runTestGit(t, root, "clone", "--quiet", bare, alicePath)
+	if err := (&dirBackend{path: alicePath, git: true, machine: "alice"}).Put(map[string]string{"app.json.age": "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	runTestGit(t, root, "clone", "--quiet", bare, bobPath)
+
+	alice := &dirBackend{path: alicePath, git: true, machine: "alice"}
+	bob := &dirBackend{path: bobPath, git: true, machine: "bob"}
+	for _, backend := range []*dirBackend{alice, bob} {
+		if _, err := backend.List(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bobHead := runTestGit(t, bobPath, "rev-parse", "HEAD")
+	if err := alice.Put(map[string]string{"app.json.age": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.Put(map[string]string{"app.json.age": "bob"}); !errors.Is(err, errRemoteChanged) {
+		t.Fatalf("Put with a rejected push = %v, want errRemoteChanged", err)
+	}
+	if head := runTestGit(t, bobPath, "rev-parse", "HEAD"); head != bobHead {
+		t.Fatalf("HEAD after a rejected push = %s, want the local commit dropped back to %s", head, bobHead)
+	}
+	if got := readTestFile(t, filepath.Join(bobPath, "app.json.age")); got != "v1" {
+		t.Fatalf("app.json.age after a rejected push = %q, want v1", got)
+	}
+
+	retry := &dirBackend{path: bobPath, git: true, machine: "bob"}
+	if content, err := retry.Fetch("app.json.age"); err != nil || content != "alice" {
+		t.Fatalf("Fetch after the fast-forward = %q, %v", content, err)
+	}
+	if err := retry.Put(map[string]string{"app.json.age": "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := runTestGit(t, bare, "show", "HEAD:app.json.age"); got != "bob" {
+		t.Fatalf("upstream app.json.age = %q, want bob", got)
+	}
+}
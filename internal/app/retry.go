@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	retryAttempts  = 4
+	retryBaseDelay = time.Second
+	retryMaxWait   = time.Minute
+)
+
+// retryTransport retries GitHub calls that failed for reasons that go away on
+// their own: 5xx responses, dropped connections and rate limits. Waits follow
+// Retry-After and X-RateLimit-Reset when GitHub sends them and fall back to
+// exponential backoff with jitter otherwise.
+//
+// timeout bounds each attempt rather than the whole call, so waiting out a
+// rate limit of up to retryMaxWait is not cut short by the client timeout.
+type retryTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func newRetryTransport(base http.RoundTripper, timeout time.Duration) *retryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if existing, ok := base.(*retryTransport); ok {
+		return existing
+	}
+	return &retryTransport{base: base, timeout: timeout}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		// Each attempt gets its own copy of the request, since the caller's
+		// must not be modified and the body has to be rewound for a retry.
+		ctx, cancel := req.Context(), context.CancelFunc(func() {})
+		if t.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		}
+		try := req.Clone(ctx)
+		if attempt > 1 && req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			try.Body = body
+		}
+		resp, err := t.base.RoundTrip(try)
+		wait, retry, limitErr := retryDecision(req, resp, err, attempt)
+		if !retry {
+			if limitErr != nil || err != nil {
+				cancel()
+				if limitErr != nil {
+					return nil, limitErr
+				}
+				return nil, err
+			}
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("github request timed out while retrying: %w", retryCause(resp, err))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// cancelBody releases an attempt's timeout once the caller is done reading
+// the response.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryDecision reports how long to wait before the next attempt. When a rate
+// limit is hit and the reset is too far away (or attempts ran out), it returns
+// an error naming the reset time instead of a bare 403.
+func retryDecision(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool, error) {
+	replayable := req.Body == nil || req.GetBody != nil
+	// A POST or PATCH that failed after it was sent may still have been
+	// applied: a second gist, or a PATCH replayed over another machine's
+	// write, is worse than failing. They are only retried when the server
+	// provably never saw them.
+	idempotent := req.Method != http.MethodPost && req.Method != http.MethodPatch
+	backoff := retryBaseDelay<<(attempt-1) + time.Duration(rand.Int63n(int64(retryBaseDelay)))
+	if err != nil {
+		ok := replayable && (idempotent || notSent(err)) && attempt < retryAttempts && req.Context().Err() == nil
+		return backoff, ok, nil
+	}
+	if wait, limited := rateLimitWait(resp); limited {
+		if wait > retryMaxWait || attempt >= retryAttempts || !replayable {
+			reset := time.Now().Add(wait).Local().Format("15:04:05")
+			resp.Body.Close()
+			return 0, false, fmt.Errorf("github rate limit exceeded (%s), try again after %s", resp.Status, reset)
+		}
+		if wait <= 0 {
+			wait = backoff
+		}
+		return wait, true, nil
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		ok := replayable && idempotent && attempt < retryAttempts
+		if wait := retryAfter(resp); wait > 0 {
+			backoff = wait
+		}
+		return backoff, ok && backoff <= retryMaxWait, nil
+	}
+	return 0, false, nil
+}
+
+// notSent reports whether a request failed before any of it reached the
+// server, which is only certain when the connection was never established.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// GitHub signals the primary rate limit with X-RateLimit-Remaining: 0 and the
+// secondary (abuse) limit with Retry-After, both on a 403 or 429.
+func rateLimitWait(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if wait := retryAfter(resp); wait > 0 {
+		return wait, true
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil {
+			return 0, true
+		}
+		return time.Until(time.Unix(reset, 0)) + time.Second, true
+	}
+	return 0, resp.StatusCode == http.StatusTooManyRequests
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+func retryCause(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("last response %s", resp.Status)
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The first attempt hangs past the per-attempt timeout; the retry resends the
+// full body on a copy of the request and leaves the caller's untouched.
+func TestRetryTransportTimesOutEachAttempt(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if attempts.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := &http.Client{Transport: newRetryTransport(nil, 200*time.Millisecond)}
+	req, err := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader(`{"files":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := req.Body
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"files":{}}` || attempts.Load() != 2 {
+		t.Fatalf("body = %q after %d attempts", got, attempts.Load())
+	}
+	if req.Body != body {
+		t.Fatal("retry replaced the caller's request body")
+	}
+	if _, ok := req.Context().Deadline(); ok {
+		t.Fatal("retry set a deadline on the caller's request")
+	}
+}
+
+// A PATCH whose attempt timed out may already have been applied, so it fails
+// instead of being sent again.
+func TestRetryTransportDoesNotResendPatch(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		attempts.Add(1)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	client := &http.Client{Transport: newRetryTransport(nil, 100*time.Millisecond)}
+	req, err := http.NewRequest(http.MethodPatch, srv.URL, strings.NewReader(`{"files":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("timed out PATCH succeeded")
+	}
+	if attempts.Load() != 1 {
+		t.Fatalf("PATCH sent %d times", attempts.Load())
+	}
+}
+
+func TestRetryDecisionAfterTransportError(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		method string
+		err    error
+		retry  bool
+	}{
+		{http.MethodGet, readErr, true},
+		{http.MethodGet, context.DeadlineExceeded, true},
+		{http.MethodPut, readErr, true},
+		{http.MethodPatch, dialErr, true},
+		{http.MethodPatch, readErr, false},
+		{http.MethodPatch, context.DeadlineExceeded, false},
+		{http.MethodPost, dialErr, true},
+		{http.MethodPost, io.ErrUnexpectedEOF, false},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "https://api.github.com/gists", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, retry, _ := retryDecision(req, nil, tt.err, 1); retry != tt.retry {
+			t.Errorf("%s after %v: retry = %v, want %v", tt.method, tt.err, retry, tt.retry)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const revocationsFileName = "revocations.json"
+
+// A Revocation withdraws a machine's keys. It is signed by the machine that
+// revoked it, and other machines only act on it when they trust that signer,
+// so writing to the remote is not enough to cut a machine out.
+//
+// A machine rotating its key revokes the old one itself and names the keys
+// that succeed it; the old key's signature is the endorsement that moves
+// trust to them.
+type Revocation struct {
+	PublicKey           string `json:"public_key"`
+	SigningKey          string `json:"signing_key,omitempty"`
+	MachineID           string `json:"machine_id,omitempty"`
+	RevokedBy           string `json:"revoked_by"`
+	RevokerKey          string `json:"revoker_key"`
+	RevokedAt           string `json:"revoked_at"`
+	Successor           string `json:"successor,omitempty"`
+	SuccessorSigningKey string `json:"successor_signing_key,omitempty"`
+	Signature           string `json:"signature"`
+}
+
+func (r Revocation) message() []byte {
+	fields := []string{"veil-revocation-v1", r.PublicKey, r.SigningKey, r.MachineID, r.RevokedBy, r.RevokerKey, r.RevokedAt}
+	if r.Successor != "" || r.SuccessorSigningKey != "" {
+		fields = append(fields, r.Successor, r.SuccessorSigningKey)
+	}
+	return []byte(strings.Join(fields, "\n"))
+}
+
+// endorsesSuccessor reports whether the revocation is a machine retiring its
+// own key in favour of a successor.
+func (r Revocation) endorsesSuccessor() bool {
+	return r.Successor != "" && r.SuccessorSigningKey != "" && r.MachineID != "" && r.RevokedBy == r.MachineID && r.RevokerKey == r.SigningKey
+}
+
+func (r Revocation) verify() bool {
+	publicKey, err := base64.StdEncoding.DecodeString(r.RevokerKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Signature)
+	return err == nil && ed25519.Verify(ed25519.PublicKey(publicKey), r.message(), signature)
+}
+
+// revoke signs and records a revocation of the keys it names for publishing on
+// the next sync. Rotation signs with the retiring identity, which the other
+// machines already trust.
+func (a *App) revoke(signer *age.X25519Identity, revocation Revocation) {
+	revocation.RevokedBy = a.config.Machine.ID
+	revocation.RevokerKey = signingPublicKey(signer)
+	revocation.RevokedAt = nowRFC3339()
+	revocation.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(signingKeyFor(signer), revocation.message()))
+	a.addRevocation(revocation)
+}
+
+func (a *App) addRevocation(revocation Revocation) {
+	for _, known := range a.config.Revocations {
+		if known.PublicKey == revocation.PublicKey && known.RevokerKey == revocation.RevokerKey {
+			return
+		}
+	}
+	a.config.Revocations = append(a.config.Revocations, revocation)
+}
+
+// mergeRemoteRevocations keeps every revocation with a valid signature, so it
+// is passed on even by machines that do not trust its signer yet, but only
+// applies those signed by a trusted machine. One whose signer is approved
+// later takes effect on the sync after the approval. Successions are taken in
+// first, repeatedly, so a machine that rotated more than once while this one
+// was away is followed to its latest key.
+func (a *App) mergeRemoteRevocations(content string) {
+	if strings.TrimSpace(content) != "" {
+		var remote []Revocation
+		if err := json.Unmarshal([]byte(content), &remote); err == nil {
+			for _, revocation := range remote {
+				if revocation.PublicKey != "" && revocation.verify() {
+					a.addRevocation(revocation)
+				}
+			}
+		}
+	}
+	for succeeded := true; succeeded; {
+		succeeded = false
+		for _, revocation := range a.config.Revocations {
+			if a.applySuccession(revocation) {
+				succeeded = true
+			}
+		}
+	}
+	for _, revocation := range a.config.Revocations {
+		if revocation.PublicKey == a.config.Machine.PublicKey {
+			continue
+		}
+		if a.checkSigner(&Signer{MachineID: revocation.RevokedBy, PublicKey: revocation.RevokerKey}) != nil {
+			continue
+		}
+		a.applyRevocation(revocation)
+	}
+}
+
+// applySuccession moves the trust a retired key had here to the successor it
+// endorsed: the successor becomes a trusted recipient and signer and the
+// machine's record takes the new keys. It only acts while the retired key is
+// still trusted and its signature checks out, so it is never applied twice.
+func (a *App) applySuccession(revocation Revocation) bool {
+	if !revocation.endorsesSuccessor() || !a.isTrustedRecipient(revocation.PublicKey) {
+		return false
+	}
+	if a.isDeniedRecipient(revocation.Successor) || a.isDeniedRecipient(revocation.SuccessorSigningKey) {
+		return false
+	}
+	if a.checkSigner(&Signer{MachineID: revocation.RevokedBy, PublicKey: revocation.RevokerKey}) != nil {
+		return false
+	}
+	successor := Signer{MachineID: revocation.MachineID, PublicKey: revocation.SuccessorSigningKey}
+	if a.isTrustedRecipient(revocation.Successor) && a.checkSigner(&successor) == nil {
+		return false
+	}
+	a.config.Recipients = uniqueStrings(append(a.config.Recipients, revocation.Successor))
+	if a.checkSigner(&successor) != nil {
+		a.config.Signers = append(a.config.Signers, successor)
+	}
+	pending := make([]PendingRecipient, 0, len(a.config.Pending))
+	for _, p := range a.config.Pending {
+		if p.PublicKey != revocation.Successor {
+			pending = append(pending, p)
+		}
+	}
+	a.config.Pending = pending
+	pendingSigners := make([]Signer, 0, len(a.config.PendingSigners))
+	for _, p := range a.config.PendingSigners {
+		if p.PublicKey != revocation.SuccessorSigningKey {
+			pendingSigners = append(pendingSigners, p)
+		}
+	}
+	a.config.PendingSigners = pendingSigners
+	if idx := a.machineIndex(revocation.MachineID); idx >= 0 && a.config.Machines[idx].PublicKey == revocation.PublicKey {
+		a.config.Machines[idx].PublicKey = revocation.Successor
+		a.config.Machines[idx].SigningKey = revocation.SuccessorSigningKey
+	}
+	return true
+}
+
+func (a *App) applyRevocation(revocation Revocation) {
+	a.dropRecipient(revocation.PublicKey)
+	a.config.Denied = uniqueStrings(append(a.config.Denied, revocation.PublicKey))
+	if revocation.SigningKey != "" {
+		a.config.Denied = uniqueStrings(append(a.config.Denied, revocation.SigningKey))
+		signers := make([]Signer, 0, len(a.config.Signers))
+		for _, signer := range a.config.Signers {
+			if signer.PublicKey != revocation.SigningKey {
+				signers = append(signers, signer)
+			}
+		}
+		a.config.Signers = signers
+	}
+	// A rotated machine keeps its ID, so only a record still holding the
+	// revoked key is removed.
+	if idx := a.machineIndex(revocation.MachineID); idx >= 0 && a.config.Machines[idx].PublicKey == revocation.PublicKey {
+		a.config.Machines = append(a.config.Machines[:idx], a.config.Machines[idx+1:]...)
+	}
+}
+
+func (a *App) publishedRevocations() string {
+	records := append([]Revocation{}, a.config.Revocations...)
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].PublicKey != records[j].PublicKey {
+			return records[i].PublicKey < records[j].PublicKey
+		}
+		return records[i].RevokerKey < records[j].RevokerKey
+	})
+	b, _ := json.MarshalIndent(records, "", "  ")
+	return string(b) + "\n"
+}
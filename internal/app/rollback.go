@@ -0,0 +1,272 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const gistCommitsPerPage = 100
+
+type GistRevision struct {
+	Version     string
+	CommittedAt string
+	Author      string
+	Additions   int
+	Deletions   int
+}
+
+type RollbackOptions struct {
+	Revision      string
+	Project       string
+	Env           string
+	Path          string
+	Token         string
+	AllowUnsigned bool
+}
+
+type RollbackPlan struct {
+	Project  string
+	Revision string
+	Changes  []SyncChange
+	restored *ProjectBundle
+}
+
+type gistCommit struct {
+	Version      string `json:"version"`
+	CommittedAt  string `json:"committed_at"`
+	ChangeStatus struct {
+		Additions int `json:"additions"`
+		Deletions int `json:"deletions"`
+	} `json:"change_status"`
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+func (c *githubClient) listGistCommits(gistID string) ([]gistCommit, error) {
+	commits := []gistCommit{}
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/gists/%s/commits?per_page=%d&page=%d", gistID, gistCommitsPerPage, page)
+		resp, err := c.request(http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("github gist history failed: %s %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		var batch []gistCommit
+		err = json.NewDecoder(resp.Body).Decode(&batch)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, batch...)
+		if len(batch) < gistCommitsPerPage {
+			return commits, nil
+		}
+	}
+}
+
+func (c *githubClient) getGistRevision(gistID, version string) (*gistResponse, error) {
+	resp, err := c.request(http.MethodGet, "/gists/"+gistID+"/"+version, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("revision %s not found in gist %s", version, gistID)
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("github gist revision failed: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var gist gistResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gist); err != nil {
+		return nil, err
+	}
+	return &gist, nil
+}
+
+func (a *App) historyClient(token string) (*githubClient, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return nil, err
+	}
+	if a.config.Remote.Type != remoteGist || a.config.Remote.ID == "" {
+		return nil, errors.New("remote history needs a gist remote (git remotes keep their own `git log`)")
+	}
+	if strings.TrimSpace(token) == "" {
+		var err error
+		if token, err = a.LoadGitHubToken(); err != nil {
+			return nil, err
+		}
+	}
+	return a.github(token)
+}
+
+func (a *App) RemoteHistory(token string) ([]GistRevision, error) {
+	gh, err := a.historyClient(token)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := gh.listGistCommits(a.config.Remote.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]GistRevision, 0, len(commits))
+	for _, commit := range commits {
+		out = append(out, GistRevision{
+			Version:     commit.Version,
+			CommittedAt: commit.CommittedAt,
+			Author:      commit.User.Login,
+			Additions:   commit.ChangeStatus.Additions,
+			Deletions:   commit.ChangeStatus.Deletions,
+		})
+	}
+	return out, nil
+}
+
+// Revisions are usually copied from `veil history --remote`, so a unique
+// prefix of the sha is accepted just like git does.
+func (a *App) resolveRevision(gh *githubClient, revision string) (string, error) {
+	revision = strings.ToLower(strings.TrimSpace(revision))
+	if revision == "" {
+		return "", errors.New("usage: veil rollback --revision SHA [-p project]")
+	}
+	if len(revision) == 40 {
+		return revision, nil
+	}
+	commits, err := gh.listGistCommits(a.config.Remote.ID)
+	if err != nil {
+		return "", err
+	}
+	match := ""
+	for _, commit := range commits {
+		if !strings.HasPrefix(commit.Version, revision) {
+			continue
+		}
+		if match != "" {
+			return "", fmt.Errorf("revision %q is ambiguous", revision)
+		}
+		match = commit.Version
+	}
+	if match == "" {
+		return "", fmt.Errorf("no gist revision matches %q (see `veil history --remote`)", revision)
+	}
+	return match, nil
+}
+
+func (a *App) PlanRollback(opts RollbackOptions) (*RollbackPlan, error) {
+	gh, err := a.historyClient(opts.Token)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := a.LoadIdentity()
+	if err != nil {
+		return nil, err
+	}
+	revision, err := a.resolveRevision(gh, opts.Revision)
+	if err != nil {
+		return nil, err
+	}
+	gist, err := gh.getGistRevision(a.config.Remote.ID, revision)
+	if err != nil {
+		return nil, err
+	}
+	project := sanitizeProjectName(opts.Project)
+	name := filepath.Base(a.projectFilePath(project))
+	content, err := (&gistBackend{gh: gh, id: a.config.Remote.ID, gist: gist}).Fetch(name)
+	if errors.Is(err, errRemoteMissing) {
+		return nil, fmt.Errorf("project %q does not exist at revision %s", project, shortRevision(revision))
+	}
+	if err != nil {
+		return nil, err
+	}
+	old, signer, err := decodeBundle(content, identity)
+	if err != nil {
+		return nil, fmt.Errorf("project %q at revision %s: %w (revisions from before `veil rotate-key` cannot be decrypted)", project, shortRevision(revision), err)
+	}
+	err = a.checkSigner(signer)
+	if errors.Is(err, errUnsignedBundle) && opts.AllowUnsigned {
+		err = nil
+	}
+	if err == nil {
+		err = checkProject(old, signer, project)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("project %q at revision %s: %w", project, shortRevision(revision), err)
+	}
+	normalizeBundle(old)
+	inEnv := func(string) bool { return true }
+	if strings.TrimSpace(opts.Env) != "" {
+		env, err := NormalizeEnv(opts.Env)
+		if err != nil {
+			return nil, err
+		}
+		inEnv = func(layer string) bool { return layer == env }
+	}
+
+	current, err := a.LoadProject(project, opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	restored := &ProjectBundle{
+		Project:           current.Project,
+		Path:              current.Path,
+		Includes:          current.Includes,
+		IncludesUpdatedAt: current.IncludesUpdatedAt,
+		Secrets:           append([]Secret(nil), current.Secrets...),
+		Tombstones:        append([]Tombstone(nil), current.Tombstones...),
+	}
+	for _, secret := range old.Secrets {
+		if !inEnv(secret.Env) {
+			continue
+		}
+		if existing, ok := GetSecret(restored, secret.Env, secret.Key); ok && sameState(keyState{secret: existing, present: true}, keyState{secret: secret, present: true}) {
+			continue
+		}
+		UpsertSecret(restored, secret.Env, secret.Key, secret.Value, secret.Group)
+		if _, err := SetSecretMetadata(restored, secret.Env, secret.Key, MetadataOf(secret)); err != nil {
+			return nil, err
+		}
+	}
+	for _, secret := range current.Secrets {
+		if !inEnv(secret.Env) {
+			continue
+		}
+		if _, ok := GetSecret(old, secret.Env, secret.Key); !ok {
+			RemoveSecret(restored, secret.Env, secret.Key)
+		}
+	}
+	return &RollbackPlan{
+		Project:  project,
+		Revision: revision,
+		Changes:  planChanges(project, current, restored, restored, nil),
+		restored: restored,
+	}, nil
+}
+
+// Rolling back writes the old values as fresh local edits (with tombstones for
+// keys that did not exist yet), so the next sync pushes them like any change.
+func (a *App) ApplyRollback(plan *RollbackPlan) error {
+	if plan == nil || plan.restored == nil {
+		return errors.New("nothing to roll back")
+	}
+	if len(plan.Changes) == 0 {
+		return nil
+	}
+	return a.SaveProject(plan.restored)
+}
+
+func shortRevision(revision string) string {
+	if len(revision) > 7 {
+		return revision[:7]
+	}
+	return revision
+}
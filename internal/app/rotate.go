@@ -0,0 +1,134 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+)
+
+func (a *App) RotateKey(opts SyncOptions) (string, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return "", err
+	}
+	old, err := a.LoadIdentity()
+	if err != nil {
+		return "", err
+	}
+	next, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", fmt.Errorf("generate age identity: %w", err)
+	}
+	oldKey := old.Recipient().String()
+	newKey := next.Recipient().String()
+
+	// Before switching, sync once with the new key added and signing with it,
+	// so the remote is readable and verifiable by the new identity alone and
+	// a failed sync after the switch can be finished by `veil sync`.
+	linked := a.config.Remote.Linked()
+	if linked {
+		a.config.Recipients = uniqueStrings(append(a.config.Recipients, newKey))
+		handover := opts
+		handover.signWith = next
+		if _, err := a.Sync(handover); err != nil {
+			a.dropNewKey(newKey)
+			return "", fmt.Errorf("sync before rotation: %w", err)
+		}
+	}
+
+	names, err := a.localProjectNames()
+	if err != nil {
+		return "", err
+	}
+	projects := map[string]*ProjectBundle{}
+	bases := map[string]*ProjectBundle{}
+	for _, name := range names {
+		b, err := os.ReadFile(a.projectFilePath(name))
+		if err != nil {
+			return "", fmt.Errorf("read project file: %w", err)
+		}
+		bundle, _, err := decodeBundle(string(b), old)
+		if err != nil {
+			return "", fmt.Errorf("project %q: %w", name, err)
+		}
+		projects[name] = bundle
+		if base := a.loadBase(name, old); base != nil {
+			bases[name] = base
+		}
+	}
+
+	transitional := uniqueStrings(append(a.config.Recipients, oldKey, newKey))
+	if err := a.writeProjects(projects, transitional, old); err != nil {
+		return "", err
+	}
+
+	previous := a.config.Machine
+	a.config.Machine.PublicKey = newKey
+	a.config.Machine.SigningKey = signingPublicKey(next)
+	if err := a.saveIdentity(next); err != nil {
+		a.config.Machine = previous
+		return "", err
+	}
+	a.identity = next
+	recipients := []string{newKey}
+	for _, key := range a.config.Recipients {
+		if key != oldKey {
+			recipients = append(recipients, key)
+		}
+	}
+	a.config.Recipients = uniqueStrings(recipients)
+	a.config.Denied = uniqueStrings(append(a.config.Denied, oldKey, previous.SigningKey))
+	// The old key endorses its successor, so machines that trust it move
+	// their trust over instead of holding the new key as pending.
+	a.revoke(old, Revocation{
+		PublicKey:           oldKey,
+		SigningKey:          previous.SigningKey,
+		MachineID:           previous.ID,
+		Successor:           newKey,
+		SuccessorSigningKey: a.config.Machine.SigningKey,
+	})
+	if err := a.SaveConfig(); err != nil {
+		return "", err
+	}
+
+	if err := a.writeProjects(projects, a.config.Recipients, next); err != nil {
+		return "", err
+	}
+	for _, base := range bases {
+		if err := a.saveBase(base, next); err != nil {
+			return "", err
+		}
+	}
+	if !linked {
+		a.touchSelfMachine()
+		return newKey, a.SaveConfig()
+	}
+	if _, err := a.Sync(opts); err != nil {
+		return newKey, fmt.Errorf("key rotated locally but sync failed (run `veil sync` once the remote is reachable): %w", err)
+	}
+	return newKey, nil
+}
+
+// dropNewKey undoes the handover sync's recipient change when it fails, so an
+// aborted rotation leaves no trusted key without an identity behind it.
+func (a *App) dropNewKey(newKey string) {
+	a.configReady = false
+	if _, err := a.LoadConfig(); err != nil {
+		return
+	}
+	a.dropRecipient(newKey)
+	_ = a.SaveConfig()
+}
+
+func (a *App) writeProjects(projects map[string]*ProjectBundle, recipients []string, identity *age.X25519Identity) error {
+	for name, bundle := range projects {
+		ciphertext, err := a.encodeBundle(bundle, recipients, identity)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(a.projectFilePath(name), []byte(ciphertext)); err != nil {
+			return fmt.Errorf("write project file: %w", err)
+		}
+	}
+	return nil
+}
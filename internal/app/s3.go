@@ -0,0 +1,346 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3DefaultRegion  = "us-east-1"
+	s3DefaultPrefix  = "veil/"
+	s3TimeFormat     = "20060102T150405Z"
+	s3DateFormat     = "20060102"
+	s3CredentialUser = "s3_"
+)
+
+type s3Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+type s3Backend struct {
+	client   *http.Client
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+	creds    s3Credentials
+	etags    map[string]string
+	now      func() time.Time
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		ETag string `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func normalizeS3Prefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+func (a *App) newS3Backend(remote RemoteConfig) (*s3Backend, error) {
+	if remote.Bucket == "" {
+		return nil, errors.New("s3 remote has no bucket (run `veil link --backend s3 --bucket NAME`)")
+	}
+	region := remote.Region
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	endpoint := remote.URL
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	parsed, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	creds, err := loadS3Credentials(remote.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Backend{
+		client:   newRemoteClient(),
+		endpoint: parsed,
+		bucket:   remote.Bucket,
+		prefix:   remote.Prefix,
+		region:   region,
+		creds:    creds,
+		etags:    map[string]string{},
+		now:      time.Now,
+	}, nil
+}
+
+func loadS3Credentials(bucket string) (s3Credentials, error) {
+	creds := s3Credentials{
+		AccessKey:    strings.TrimSpace(os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretKey:    strings.TrimSpace(os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken: strings.TrimSpace(os.Getenv("AWS_SESSION_TOKEN")),
+	}
+	if creds.AccessKey != "" && creds.SecretKey != "" {
+		return creds, nil
+	}
+	stored, err := keyring.Get(serviceName, s3CredentialUser+bucket)
+	if err == nil {
+		access, secret, ok := strings.Cut(strings.TrimSpace(stored), ":")
+		if ok && access != "" && secret != "" {
+			return s3Credentials{AccessKey: access, SecretKey: secret}, nil
+		}
+	}
+	return creds, errors.New("missing S3 credentials: set AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY or pass --access-key to `veil link`")
+}
+
+func (a *App) StoreS3Credentials(bucket, accessKey, secretKey string) error {
+	accessKey = strings.TrimSpace(accessKey)
+	secretKey = strings.TrimSpace(secretKey)
+	if bucket == "" || accessKey == "" || secretKey == "" {
+		return errors.New("bucket, access key and secret key are required")
+	}
+	if err := keyring.Set(serviceName, s3CredentialUser+bucket, accessKey+":"+secretKey); err != nil {
+		return fmt.Errorf("store s3 credentials in keychain: %w", err)
+	}
+	return nil
+}
+
+func (b *s3Backend) Type() string {
+	return remoteS3
+}
+
+func (b *s3Backend) List() ([]string, error) {
+	names := []string{}
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if b.prefix != "" {
+			query.Set("prefix", b.prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := b.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("s3 list failed: %s %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("decode s3 listing: %w", err)
+		}
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, b.prefix)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			b.etags[name] = object.ETag
+			names = append(names, name)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *s3Backend) Fetch(name string) (string, error) {
+	resp, err := b.do(http.MethodGet, b.prefix+name, nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		b.etags[name] = ""
+		return "", fmt.Errorf("%s: %w", name, errRemoteMissing)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("s3 get %s failed: %s %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	b.etags[name] = resp.Header.Get("ETag")
+	return string(body), nil
+}
+
+// Each object is written with If-Match against the ETag seen while reading, or
+// If-None-Match for objects that did not exist, so a concurrent writer turns
+// into errRemoteChanged instead of a silently lost update.
+//
+// S3 has no multi-object transaction, so a 412 part-way leaves the objects
+// before it written. That is safe: each of them passed its own precondition
+// and holds a full merge, and the retried sync reads them back as remote
+// state and merges them again with nothing lost.
+func (b *s3Backend) Put(files map[string]string) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		headers := map[string]string{"Content-Type": "application/octet-stream"}
+		if etag, seen := b.etags[name]; seen && etag != "" {
+			headers["If-Match"] = etag
+		} else {
+			headers["If-None-Match"] = "*"
+		}
+		resp, err := b.do(http.MethodPut, b.prefix+name, nil, []byte(files[name]), headers)
+		if err != nil {
+			return err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict:
+			return fmt.Errorf("%s: %w", name, errRemoteChanged)
+		case resp.StatusCode >= 300:
+			return fmt.Errorf("s3 put %s failed: %s %s", name, resp.Status, strings.TrimSpace(string(body)))
+		}
+		b.etags[name] = resp.Header.Get("ETag")
+	}
+	return nil
+}
+
+func (b *s3Backend) Recipients() ([]string, error) {
+	return remoteRecipients(b)
+}
+
+func (b *s3Backend) objectURL(key string, query url.Values) *url.URL {
+	u := *b.endpoint
+	if strings.HasSuffix(u.Host, ".amazonaws.com") && !strings.Contains(b.bucket, ".") {
+		u.Host = b.bucket + "." + u.Host
+		u.Path = "/" + key
+	} else {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + b.bucket
+		if key != "" {
+			u.Path += "/" + key
+		}
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = ""
+	if query != nil {
+		u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+	return &u
+}
+
+func (b *s3Backend) do(method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	u := b.objectURL(key, query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	b.sign(req, u, body)
+	return b.client.Do(req)
+}
+
+func (b *s3Backend) sign(req *http.Request, u *url.URL, body []byte) {
+	now := b.now().UTC()
+	amzDate := now.Format(s3TimeFormat)
+	date := now.Format(s3DateFormat)
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.creds.SessionToken)
+	}
+
+	signed := map[string]string{"host": u.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "if-match" || lower == "if-none-match" {
+			signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{s3Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.creds.SecretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, b.creds.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+const quarantineTimestamp = "20060102T150405Z"
+
+var (
+	errUnsignedBundle  = errors.New("bundle is not signed")
+	errBadSignature    = errors.New("bundle signature does not verify")
+	errUntrustedSigner = errors.New("bundle is signed by an untrusted key")
+	errProjectMismatch = errors.New("bundle is signed for a different project")
+	signingKeyDomain   = []byte("veil-signing-key-v1\n")
+	bundleDomain       = []byte("veil-bundle-v2\n")
+)
+
+type Signer struct {
+	MachineID   string `json:"machine_id"`
+	PublicKey   string `json:"public_key"`
+	FirstSeenAt string `json:"first_seen_at,omitempty"`
+}
+
+type signedBundle struct {
+	Project    string          `json:"project"`
+	Signer     string          `json:"signer"`
+	SigningKey string          `json:"signing_key"`
+	Signature  string          `json:"signature"`
+	Bundle     json.RawMessage `json:"bundle"`
+}
+
+// The signing key is derived from the age identity so that every key storage
+// backend, rotation and recovery path carries it without a second secret.
+func signingKeyFor(identity *age.X25519Identity) ed25519.PrivateKey {
+	seed := sha256.Sum256(append(append([]byte(nil), signingKeyDomain...), identity.String()...))
+	return ed25519.NewKeyFromSeed(seed[:])
+}
+
+func signingPublicKey(identity *age.X25519Identity) string {
+	return base64.StdEncoding.EncodeToString(signingKeyFor(identity).Public().(ed25519.PublicKey))
+}
+
+// signedMessage binds the project name to the payload, so a valid blob cannot
+// be replayed under another project's file name.
+func signedMessage(project string, payload []byte) []byte {
+	message := append(append([]byte(nil), bundleDomain...), project...)
+	return append(append(message, '\n'), payload...)
+}
+
+func signBundle(bundle *ProjectBundle, machineID string, identity *age.X25519Identity) ([]byte, error) {
+	signed := *bundle
+	signed.Project = sanitizeProjectName(bundle.Project)
+	payload, err := json.Marshal(&signed)
+	if err != nil {
+		return nil, fmt.Errorf("encode project: %w", err)
+	}
+	key := signingKeyFor(identity)
+	envelope := signedBundle{
+		Project:    signed.Project,
+		Signer:     machineID,
+		SigningKey: signingPublicKey(identity),
+		Signature:  base64.StdEncoding.EncodeToString(ed25519.Sign(key, signedMessage(signed.Project, payload))),
+		Bundle:     payload,
+	}
+	return json.MarshalIndent(envelope, "", "  ")
+}
+
+func openBundle(plain []byte) (*ProjectBundle, *Signer, error) {
+	var envelope signedBundle
+	if err := json.Unmarshal(plain, &envelope); err != nil {
+		return nil, nil, fmt.Errorf("decode: %w", err)
+	}
+	if len(envelope.Bundle) == 0 {
+		bundle := &ProjectBundle{}
+		if err := json.Unmarshal(plain, bundle); err != nil {
+			return nil, nil, fmt.Errorf("decode: %w", err)
+		}
+		return bundle, nil, nil
+	}
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, envelope.Bundle); err != nil {
+		return nil, nil, fmt.Errorf("decode: %w", err)
+	}
+	// The signature covers the project name, so an envelope without one
+	// cannot be checked against the file it was stored under.
+	if envelope.Project == "" {
+		return nil, nil, errBadSignature
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(envelope.SigningKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, nil, errBadSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(publicKey), signedMessage(envelope.Project, payload.Bytes()), signature) {
+		return nil, nil, errBadSignature
+	}
+	bundle := &ProjectBundle{}
+	if err := json.Unmarshal(payload.Bytes(), bundle); err != nil {
+		return nil, nil, fmt.Errorf("decode: %w", err)
+	}
+	if sanitizeProjectName(bundle.Project) != envelope.Project {
+		return nil, nil, errProjectMismatch
+	}
+	return bundle, &Signer{MachineID: envelope.Signer, PublicKey: envelope.SigningKey}, nil
+}
+
+// checkProject rejects a signed bundle found under another project's name.
+func checkProject(bundle *ProjectBundle, signer *Signer, project string) error {
+	if signer == nil || sanitizeProjectName(bundle.Project) == sanitizeProjectName(project) {
+		return nil
+	}
+	return fmt.Errorf("%w (%q, stored as %q)", errProjectMismatch, bundle.Project, project)
+}
+
+func (a *App) checkSigner(signer *Signer) error {
+	if signer == nil {
+		return errUnsignedBundle
+	}
+	if signer.MachineID == a.config.Machine.ID && signer.PublicKey == a.config.Machine.SigningKey {
+		return nil
+	}
+	for _, trusted := range a.config.Signers {
+		if trusted.MachineID == signer.MachineID && trusted.PublicKey == signer.PublicKey {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w (machine %s)", errUntrustedSigner, signer.MachineID)
+}
+
+func (a *App) notePendingSigner(signer *Signer) bool {
+	if signer == nil || a.isDeniedRecipient(signer.PublicKey) {
+		return false
+	}
+	for _, pending := range a.config.PendingSigners {
+		if pending.PublicKey == signer.PublicKey {
+			return false
+		}
+	}
+	a.config.PendingSigners = append(a.config.PendingSigners, Signer{
+		MachineID:   signer.MachineID,
+		PublicKey:   signer.PublicKey,
+		FirstSeenAt: nowRFC3339(),
+	})
+	return true
+}
+
+func (a *App) quarantine(project, ciphertext string) (string, error) {
+	dir := filepath.Join(a.HomeDir, "quarantine")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create quarantine directory: %w", err)
+	}
+	// A quarantined blob stays on the remote until someone resolves it, so
+	// later syncs find the same ciphertext again and reuse its earlier copy.
+	earlier, _ := filepath.Glob(filepath.Join(dir, sanitizeProjectName(project)+".*.json.age"))
+	for _, path := range earlier {
+		if b, err := os.ReadFile(path); err == nil && string(b) == ciphertext {
+			return path, nil
+		}
+	}
+	name := sanitizeProjectName(project) + "." + time.Now().UTC().Format(quarantineTimestamp) + ".json.age"
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(ciphertext), 0o600); err != nil {
+		return "", fmt.Errorf("write quarantine file: %w", err)
+	}
+	return path, nil
+}
+
+func (a *App) ensureSigningKey(identity *age.X25519Identity) error {
+	key := signingPublicKey(identity)
+	if strings.TrimSpace(a.config.Machine.SigningKey) == key {
+		return nil
+	}
+	a.config.Machine.SigningKey = key
+	return a.SaveConfig()
+}
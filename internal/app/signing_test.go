@@ -0,0 +1,39 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestOpenBundleRequiresProject(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signBundle(&ProjectBundle{Project: "App"}, "machine", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, signer, err := openBundle(signed)
+	if err != nil || bundle.Project != "app" || signer.MachineID != "machine" {
+		t.Fatalf("openBundle = %+v, %+v, %v", bundle, signer, err)
+	}
+
+	var envelope signedBundle
+	if err := json.Unmarshal(signed, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	for name, project := range map[string]string{"missing": "", "other": "other"} {
+		envelope.Project = project
+		tampered, err := json.Marshal(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := openBundle(tampered); !errors.Is(err, errBadSignature) {
+			t.Errorf("%s project: openBundle = %v, want errBadSignature", name, err)
+		}
+	}
+}
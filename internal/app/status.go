@@ -0,0 +1,108 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+type ProjectSyncState struct {
+	Revision       int    `json:"revision"`
+	PushedRevision int    `json:"pushed_revision"`
+	ChangedAt      string `json:"changed_at,omitempty"`
+	PushedAt       string `json:"pushed_at,omitempty"`
+	Recipients     string `json:"recipients,omitempty"`
+}
+
+type ProjectStatus struct {
+	Project   string
+	Revision  int
+	Pushed    int
+	ChangedAt string
+	PushedAt  string
+	Pending   bool
+}
+
+type StatusReport struct {
+	Remote       string
+	LastSyncedAt string
+	Projects     []ProjectStatus
+}
+
+func (r *StatusReport) PendingCount() int {
+	count := 0
+	for _, project := range r.Projects {
+		if project.Pending {
+			count++
+		}
+	}
+	return count
+}
+
+// The fingerprint of the recipient set a blob was last pushed with lets sync
+// notice that approvals, revocations or rotations need a re-encrypted upload
+// even though no secret changed.
+func recipientsFingerprint(recipients []string) string {
+	sorted := uniqueStrings(recipients)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (a *App) markProjectChanged(project string) {
+	if a.config.ProjectSync == nil {
+		a.config.ProjectSync = map[string]ProjectSyncState{}
+	}
+	state := a.config.ProjectSync[project]
+	state.Revision++
+	state.ChangedAt = nowRFC3339()
+	a.config.ProjectSync[project] = state
+}
+
+func (a *App) markProjectPushed(project string, recipients []string) {
+	if a.config.ProjectSync == nil {
+		a.config.ProjectSync = map[string]ProjectSyncState{}
+	}
+	state := a.config.ProjectSync[project]
+	state.PushedRevision = state.Revision
+	state.PushedAt = nowRFC3339()
+	state.Recipients = recipientsFingerprint(recipients)
+	a.config.ProjectSync[project] = state
+}
+
+func (a *App) projectNeedsPush(project string, recipients []string) bool {
+	state, ok := a.config.ProjectSync[project]
+	if !ok {
+		return true
+	}
+	return state.Revision > state.PushedRevision || state.Recipients != recipientsFingerprint(recipients)
+}
+
+func (a *App) Status() (*StatusReport, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return nil, err
+	}
+	names, err := a.localProjectNames()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	report := &StatusReport{
+		Remote:       a.config.Remote.String(),
+		LastSyncedAt: a.config.Remote.LastSyncedAt,
+		Projects:     make([]ProjectStatus, 0, len(names)),
+	}
+	for _, name := range names {
+		state := a.config.ProjectSync[name]
+		report.Projects = append(report.Projects, ProjectStatus{
+			Project:   name,
+			Revision:  state.Revision,
+			Pushed:    state.PushedRevision,
+			ChangedAt: state.ChangedAt,
+			PushedAt:  state.PushedAt,
+			Pending:   a.config.Remote.Linked() && a.projectNeedsPush(name, a.config.Recipients),
+		})
+	}
+	return report, nil
+}
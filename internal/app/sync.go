@@ -0,0 +1,411 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+)
+
+const syncAttempts = 3
+
+type LinkOptions struct {
+	Backend   string
+	Token     string
+	GistID    string
+	Path      string
+	URL       string
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	User      string
+	Password  string
+}
+
+func (a *App) Link(opts LinkOptions) error {
+	if _, err := a.LoadConfig(); err != nil {
+		return err
+	}
+	if !a.IsInitialized() {
+		return errors.New("veil is not initialized (run `veil init`)")
+	}
+	identity, err := a.LoadIdentity()
+	if err != nil {
+		return err
+	}
+	current := a.config.Remote
+	remote := RemoteConfig{Type: strings.ToLower(strings.TrimSpace(opts.Backend))}
+	if remote.Type == "" {
+		remote.Type = current.Type
+	}
+	if remote.Type == "" {
+		remote.Type = remoteGist
+	}
+	if !validRemoteType(remote.Type) {
+		return fmt.Errorf("unknown backend %q (use %s)", remote.Type, strings.Join(remoteKinds, ", "))
+	}
+	switch remote.Type {
+	case remoteGist:
+		remote.ID = strings.TrimSpace(opts.GistID)
+		if remote.ID == "" && current.Type == remoteGist {
+			remote.ID = current.ID
+			remote.Owner = current.Owner
+		}
+	case remoteS3:
+		remote.Bucket = strings.TrimSpace(opts.Bucket)
+		remote.Prefix = normalizeS3Prefix(opts.Prefix)
+		remote.Region = strings.TrimSpace(opts.Region)
+		remote.URL = strings.TrimSpace(opts.URL)
+		if remote.Bucket == "" && current.Type == remoteS3 {
+			remote.Bucket, remote.Prefix, remote.Region, remote.URL = current.Bucket, current.Prefix, current.Region, current.URL
+		}
+		if remote.Bucket == "" {
+			return errors.New("usage: veil link --backend s3 --bucket NAME [--prefix veil/] [--region REGION] [--endpoint URL]")
+		}
+		if opts.Prefix == "" && remote.Prefix == "" {
+			remote.Prefix = s3DefaultPrefix
+		}
+		if opts.AccessKey != "" {
+			if opts.SecretKey == "" && !a.noPrompt && term.IsTerminal(os.Stdin.Fd()) {
+				if opts.SecretKey, err = readPassphrase("S3 secret key: "); err != nil {
+					return err
+				}
+			}
+			if err := a.StoreS3Credentials(remote.Bucket, opts.AccessKey, opts.SecretKey); err != nil {
+				return err
+			}
+		}
+	case remoteWebDAV:
+		remote.URL = strings.TrimSpace(opts.URL)
+		if remote.URL == "" && current.Type == remoteWebDAV {
+			remote.URL = current.URL
+		}
+		if remote.URL == "" {
+			return errors.New("usage: veil link --backend webdav --url https://host/remote.php/dav/files/USER/veil [--user NAME]")
+		}
+		if opts.User != "" {
+			if opts.Password == "" && !a.noPrompt && term.IsTerminal(os.Stdin.Fd()) {
+				if opts.Password, err = readPassphrase("WebDAV password: "); err != nil {
+					return err
+				}
+			}
+			if err := a.StoreWebDAVCredentials(remote.URL, opts.User, opts.Password); err != nil {
+				return err
+			}
+		}
+	default:
+		remote.Path = strings.TrimSpace(opts.Path)
+		if remote.Path == "" && current.Type == remote.Type {
+			remote.Path = current.Path
+		}
+		if remote.Path == "" {
+			return fmt.Errorf("usage: veil link --backend %s --path DIR", remote.Type)
+		}
+		if remote.Path, err = filepath.Abs(remote.Path); err != nil {
+			return fmt.Errorf("resolve remote path: %w", err)
+		}
+	}
+	if remote.String() == current.String() {
+		remote = current
+	}
+
+	if remote.Type == remoteGist && remote.ID == "" {
+		token := opts.Token
+		if strings.TrimSpace(token) == "" {
+			if token, err = a.LoadGitHubToken(); err != nil {
+				return err
+			}
+		}
+		files := map[string]string{recipientsFileName: identity.Recipient().String() + "\n"}
+		gh, err := a.github(token)
+		if err != nil {
+			return err
+		}
+		gist, err := gh.createGist(files)
+		if err != nil {
+			return err
+		}
+		remote.ID = gist.ID
+		remote.Owner = gist.Owner.Login
+		opts.Token = token
+	}
+	backend, err := a.backendFor(remote, opts.Token)
+	if err != nil {
+		return err
+	}
+	recipients, err := backend.Recipients()
+	if err != nil {
+		return err
+	}
+	machines, err := fetchOptional(backend, machinesFileName)
+	if err != nil {
+		return err
+	}
+	revocations, err := fetchOptional(backend, revocationsFileName)
+	if err != nil {
+		return err
+	}
+	if gb, ok := backend.(*gistBackend); ok && gb.gist.Owner.Login != "" {
+		remote.Owner = gb.gist.Owner.Login
+	}
+
+	a.config.Recipients = uniqueStrings(append(a.config.Recipients, identity.Recipient().String()))
+	a.mergeRemoteRevocations(revocations)
+	a.mergeRemoteRecipients(recipients)
+	a.mergeRemoteMachines(machines)
+	a.touchSelfMachine()
+
+	files := map[string]string{
+		recipientsFileName:  strings.Join(a.publishedRecipients(), "\n") + "\n",
+		machinesFileName:    a.publishedMachines(),
+		revocationsFileName: a.publishedRevocations(),
+	}
+	if err := backend.Put(files); err != nil {
+		return err
+	}
+	a.config.Remote = remote
+	return a.SaveConfig()
+}
+
+// SyncIncompleteError is returned alongside a report when some projects could
+// not be read from the remote; every other project was still synced.
+type SyncIncompleteError struct {
+	Failed []ProjectError
+}
+
+func (e *SyncIncompleteError) Error() string {
+	parts := make([]string, 0, len(e.Failed))
+	for _, failure := range e.Failed {
+		parts = append(parts, failure.Error())
+	}
+	return fmt.Sprintf("sync incomplete, %d project(s) failed: %s", len(e.Failed), strings.Join(parts, "; "))
+}
+
+func (a *App) Sync(opts SyncOptions) (*SyncReport, error) {
+	var report *SyncReport
+	var err error
+	for attempt := 0; attempt < syncAttempts; attempt++ {
+		report, err = a.syncOnce(opts)
+		if err != nil {
+			// Recipients and machines merged from the remote only live in
+			// memory until the final save; drop them so a failed sync
+			// leaves the config exactly as it was on disk.
+			a.configReady = false
+		}
+		if err == nil && len(report.Failed) > 0 {
+			return report, &SyncIncompleteError{Failed: report.Failed}
+		}
+		if !errors.Is(err, errRemoteChanged) {
+			return report, err
+		}
+	}
+	return nil, fmt.Errorf("%w; another machine kept syncing, try again", err)
+}
+
+func (a *App) syncOnce(opts SyncOptions) (*SyncReport, error) {
+	if _, err := a.LoadConfig(); err != nil {
+		return nil, err
+	}
+	if !a.config.Remote.Linked() {
+		return nil, errors.New("no remote linked (run `veil link`)")
+	}
+	identity, err := a.LoadIdentity()
+	if err != nil {
+		return nil, err
+	}
+	backend, err := a.openBackend(opts.Token)
+	if err != nil {
+		return nil, err
+	}
+	// A dry run must not move the git checkout; it previews the fetched
+	// upstream instead.
+	if db, ok := backend.(*dirBackend); ok {
+		db.readOnly = opts.DryRun
+	}
+	remoteRecipients, err := backend.Recipients()
+	if err != nil {
+		return nil, err
+	}
+	machines, err := fetchOptional(backend, machinesFileName)
+	if err != nil {
+		return nil, err
+	}
+	revocations, err := fetchOptional(backend, revocationsFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SyncReport{}
+	a.config.Recipients = uniqueStrings(append(a.config.Recipients, identity.Recipient().String()))
+	a.mergeRemoteRevocations(revocations)
+	report.NewPending = a.mergeRemoteRecipients(remoteRecipients)
+	moved, warnings := a.mergeRemoteMachines(machines)
+	report.NewPending = append(report.NewPending, moved...)
+	report.Warnings = warnings
+	a.touchSelfMachine()
+
+	names, err := backend.List()
+	if err != nil {
+		return nil, err
+	}
+	remote := map[string]string{}
+	failed := map[string]bool{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json.age") {
+			continue
+		}
+		project := sanitizeProjectName(strings.TrimSuffix(name, ".json.age"))
+		content, err := backend.Fetch(name)
+		if errors.Is(err, errRemoteMissing) {
+			continue
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, ProjectError{Project: project, Err: err})
+			failed[project] = true
+			continue
+		}
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		remote[project] = content
+	}
+	localNames, err := a.localProjectNames()
+	if err != nil {
+		return nil, err
+	}
+	projects := make([]string, 0, len(remote)+len(localNames))
+	for name := range remote {
+		projects = append(projects, name)
+	}
+	projects = uniqueStrings(append(projects, localNames...))
+
+	merged := map[string]*ProjectBundle{}
+	ciphertexts := map[string]string{}
+	files := map[string]string{}
+	pushed := []string{}
+	for _, project := range projects {
+		// A project whose remote copy could not be read must not be pushed,
+		// or the local copy would overwrite data this machine never saw.
+		if failed[project] {
+			continue
+		}
+		// An unreadable local copy fails the project rather than counting as
+		// absent, or the merge result would be written over it.
+		var local *ProjectBundle
+		if b, readErr := os.ReadFile(a.projectFilePath(project)); readErr == nil {
+			if local, _, err = decodeBundle(string(b), identity); err != nil {
+				report.Failed = append(report.Failed, ProjectError{Project: project, Err: fmt.Errorf("decrypt local copy: %w", err)})
+				continue
+			}
+		} else if !errors.Is(readErr, os.ErrNotExist) {
+			report.Failed = append(report.Failed, ProjectError{Project: project, Err: fmt.Errorf("read local copy: %w", readErr)})
+			continue
+		}
+		base := a.loadBase(project, identity)
+		var remoteBundle *ProjectBundle
+		if content, ok := remote[project]; ok {
+			bundle, signer, err := decodeBundle(content, identity)
+			if err != nil && !errors.Is(err, errBadSignature) {
+				report.Failed = append(report.Failed, ProjectError{Project: project, Err: fmt.Errorf("decrypt remote copy: %w", err)})
+				continue
+			}
+			if err == nil {
+				err = a.checkSigner(signer)
+				if errors.Is(err, errUnsignedBundle) && opts.AllowUnsigned {
+					err = nil
+				}
+				if errors.Is(err, errUntrustedSigner) && a.notePendingSigner(signer) {
+					report.NewPending = append(report.NewPending, "signer "+signer.MachineID)
+				}
+			}
+			if err == nil {
+				err = checkProject(bundle, signer, project)
+			}
+			// A quarantined project is neither merged nor pushed: the blob
+			// stays on the remote for a trusted machine to resolve, and the
+			// local copy is kept as it is.
+			if err != nil {
+				report.Quarantined = append(report.Quarantined, QuarantinedBlob{Project: project, Reason: err.Error()})
+				continue
+			}
+			remoteBundle = bundle
+		}
+		if local == nil && remoteBundle == nil {
+			report.Skipped = append(report.Skipped, project)
+			continue
+		}
+		result, conflicts := mergeBundles(base, local, remoteBundle)
+		result.Project = project
+		pruneTombstones(result, identity.Recipient().String(), a.config.Recipients)
+		if local == nil || !bundlesEqual(local, result) {
+			report.Pulled = append(report.Pulled, project)
+		}
+		report.Conflicts = append(report.Conflicts, conflicts...)
+		report.Plan = append(report.Plan, planChanges(project, local, remoteBundle, result, conflicts)...)
+		signWith := identity
+		if opts.signWith != nil {
+			signWith = opts.signWith
+		}
+		ciphertext, err := a.encodeBundle(result, a.config.Recipients, signWith)
+		if err != nil {
+			return nil, err
+		}
+		merged[project] = result
+		name := filepath.Base(a.projectFilePath(project))
+		ciphertexts[name] = ciphertext
+		if remoteBundle == nil || !bundlesEqual(remoteBundle, result) || a.projectNeedsPush(project, a.config.Recipients) {
+			files[name] = ciphertext
+			pushed = append(pushed, project)
+		}
+	}
+
+	if opts.DryRun {
+		// Drop the in-memory merge of remote recipients and signers so the
+		// next LoadConfig sees exactly what is on disk.
+		a.configReady = false
+		return report, nil
+	}
+
+	files[recipientsFileName] = strings.Join(a.publishedRecipients(), "\n") + "\n"
+	files[machinesFileName] = a.publishedMachines()
+	files[revocationsFileName] = a.publishedRevocations()
+	if err := backend.Put(files); err != nil {
+		return nil, err
+	}
+	for _, project := range pushed {
+		a.markProjectPushed(project, a.config.Recipients)
+	}
+
+	// Nothing local is touched until every remote read and the push have
+	// succeeded, so a network failure above leaves the store as it was.
+	for i, blob := range report.Quarantined {
+		path, err := a.quarantine(blob.Project, remote[blob.Project])
+		if err != nil {
+			return nil, err
+		}
+		report.Quarantined[i].Path = path
+	}
+	for _, project := range projects {
+		bundle, ok := merged[project]
+		if !ok {
+			continue
+		}
+		name := filepath.Base(a.projectFilePath(project))
+		if err := writeFileAtomic(a.projectFilePath(project), []byte(ciphertexts[name])); err != nil {
+			return nil, fmt.Errorf("write project file: %w", err)
+		}
+		if err := a.saveBase(bundle, identity); err != nil {
+			return nil, err
+		}
+		if bundle.Path != "" {
+			a.registerProject(project, bundle.Path)
+		}
+	}
+	a.config.Remote.LastSyncedAt = nowRFC3339()
+	return report, a.SaveConfig()
+}
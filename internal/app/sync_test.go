@@ -0,0 +1,323 @@
+package app
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLinkCreatesGist(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	if alice.config.Remote.Type != remoteGist || alice.config.Remote.ID != "gist1" || alice.config.Remote.Owner != "octocat" {
+		t.Fatalf("remote = %+v", alice.config.Remote)
+	}
+	files := fake.files()
+	if got := parseRecipients(files[recipientsFileName]); !slices.Equal(got, []string{alice.config.Machine.PublicKey}) {
+		t.Fatalf("recipients.txt = %v", got)
+	}
+	var machines []MachineRecord
+	if err := json.Unmarshal([]byte(files[machinesFileName]), &machines); err != nil {
+		t.Fatal(err)
+	}
+	if len(machines) != 1 || machines[0].Name != "alice" || machines[0].ID != alice.config.Machine.ID {
+		t.Fatalf("machines.json = %+v", machines)
+	}
+}
+
+// Linking to an existing gist trusts nothing found there, and the machines
+// already on it see the newcomer as pending.
+func TestLinkHoldsRemoteKeysPending(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	bob := newGistTestApp(t, srv, "bob")
+	if err := bob.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	if bob.isTrustedRecipient(alice.config.Machine.PublicKey) || !bob.isPendingRecipient(alice.config.Machine.PublicKey) {
+		t.Fatal("bob trusted alice's key on link")
+	}
+	if got := parseRecipients(fake.files()[recipientsFileName]); !slices.Equal(got, []string{bob.config.Machine.PublicKey}) {
+		t.Fatalf("recipients.txt after bob's link = %v, want only bob's key", got)
+	}
+
+	report := syncTest(t, alice)
+	if !slices.Contains(report.NewPending, bob.config.Machine.PublicKey) || alice.isTrustedRecipient(bob.config.Machine.PublicKey) {
+		t.Fatalf("alice: new pending = %v", report.NewPending)
+	}
+	// Alice publishes only what she trusts, and still trusts herself.
+	if got := parseRecipients(fake.files()[recipientsFileName]); !slices.Equal(got, []string{alice.config.Machine.PublicKey}) {
+		t.Fatalf("recipients.txt after alice's sync = %v", got)
+	}
+}
+
+func TestSyncCarriesEditsAndDeletes(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	bob := newGistTestApp(t, srv, "bob")
+	if err := bob.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	trustEachOther(t, alice, bob)
+
+	setTestSecret(t, alice, "api", "TOKEN", "one")
+	setTestSecret(t, alice, "api", "GONE", "soon")
+	syncTest(t, alice)
+	report := syncTest(t, bob)
+	if !slices.Contains(report.Pulled, "api") || testSecret(t, bob, "api", "TOKEN") != "one" {
+		t.Fatalf("bob did not pull api: %+v", report)
+	}
+	if _, ok := fake.files()["api.json.age"]; !ok {
+		t.Fatal("api.json.age not pushed")
+	}
+
+	bundle, err := bob.LoadProject("api", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !RemoveSecret(bundle, "", "GONE") {
+		t.Fatal("GONE not deleted")
+	}
+	UpsertSecret(bundle, "", "TOKEN", "two", "")
+	if err := bob.SaveProject(bundle); err != nil {
+		t.Fatal(err)
+	}
+	syncTest(t, bob)
+	syncTest(t, alice)
+	if got := testSecret(t, alice, "api", "TOKEN"); got != "two" {
+		t.Fatalf("alice TOKEN = %q, want two", got)
+	}
+	if got := testSecret(t, alice, "api", "GONE"); got != "" {
+		t.Fatalf("alice GONE = %q, want deleted", got)
+	}
+}
+
+func TestSyncDryRunWritesNothing(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	setTestSecret(t, alice, "api", "TOKEN", "one")
+	before := fake.patches
+	report, err := alice.Sync(SyncOptions{Token: "token", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.patches != before {
+		t.Fatal("dry run wrote to the gist")
+	}
+	if len(report.Plan) == 0 {
+		t.Fatal("dry run planned nothing")
+	}
+	if _, ok := fake.files()["api.json.age"]; ok {
+		t.Fatal("dry run pushed api.json.age")
+	}
+}
+
+// A blob from an untrusted signer is quarantined and left on the remote for a
+// trusted machine to resolve, not overwritten by the local copy.
+func TestSyncLeavesQuarantinedBlobOnRemote(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	bob := newGistTestApp(t, srv, "bob")
+	if err := bob.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	// Bob encrypts to alice but alice has not approved bob's signing key.
+	if _, err := bob.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	bob.config.Recipients = append(bob.config.Recipients, alice.config.Machine.PublicKey)
+	if err := bob.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	setTestSecret(t, bob, "api", "TOKEN", "bob")
+	syncTest(t, bob)
+	pushed := fake.files()["api.json.age"]
+
+	setTestSecret(t, alice, "api", "TOKEN", "alice")
+	report, err := alice.Sync(SyncOptions{Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Quarantined) != 1 || report.Quarantined[0].Project != "api" {
+		t.Fatalf("quarantined = %+v", report.Quarantined)
+	}
+	if fake.files()["api.json.age"] != pushed {
+		t.Fatal("alice overwrote the quarantined blob")
+	}
+	if got := testSecret(t, alice, "api", "TOKEN"); got != "alice" {
+		t.Fatalf("alice TOKEN = %q, want her own value kept", got)
+	}
+}
+
+// A key disappearing from recipients.txt removes nobody; a revocation signed
+// by a trusted machine does.
+func TestSyncRemovesMachinesOnlyOnSignedRevocation(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	bob := newGistTestApp(t, srv, "bob")
+	if err := bob.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	carol := newGistTestApp(t, srv, "carol")
+	if err := carol.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	trustEachOther(t, alice, bob, carol)
+	for _, app := range []*App{alice, bob, carol} {
+		syncTest(t, app)
+	}
+
+	fake.write(map[string]string{recipientsFileName: carol.config.Machine.PublicKey + "\n"})
+	syncTest(t, carol)
+	if !carol.isTrustedRecipient(alice.config.Machine.PublicKey) || !carol.isTrustedRecipient(bob.config.Machine.PublicKey) {
+		t.Fatal("a rewritten recipients.txt removed trusted machines")
+	}
+
+	if _, err := alice.RevokeMachine("bob", SyncOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	syncTest(t, carol)
+	if carol.isTrustedRecipient(bob.config.Machine.PublicKey) || !carol.isDeniedRecipient(bob.config.Machine.PublicKey) {
+		t.Fatal("carol kept bob after alice's revocation")
+	}
+	if !strings.Contains(fake.files()[revocationsFileName], bob.config.Machine.PublicKey) {
+		t.Fatal("revocation not published")
+	}
+}
+
+// A deleted key's tombstone is pruned once every trusted machine has synced
+// it, which takes each machine's acknowledgement being pushed in turn.
+func TestSyncPrunesTombstoneSeenByEveryMachine(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	bob := newGistTestApp(t, srv, "bob")
+	if err := bob.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	carol := newGistTestApp(t, srv, "carol")
+	if err := carol.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	trustEachOther(t, alice, bob, carol)
+	setTestSecret(t, alice, "api", "KEEP", "k")
+	setTestSecret(t, alice, "api", "GONE", "g")
+	for _, app := range []*App{alice, bob, carol} {
+		syncTest(t, app)
+	}
+
+	bundle, err := alice.LoadProject("api", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RemoveSecret(bundle, "", "GONE")
+	if err := alice.SaveProject(bundle); err != nil {
+		t.Fatal(err)
+	}
+	remoteTombstones := func() []Tombstone {
+		t.Helper()
+		identity, err := alice.LoadIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote, _, err := decodeBundle(fake.files()["api.json.age"], identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return remote.Tombstones
+	}
+	syncTest(t, alice)
+	syncTest(t, bob)
+	if got := remoteTombstones(); len(got) != 1 || len(got[0].SeenBy) != 2 {
+		t.Fatalf("tombstones after alice and bob = %+v, want one seen by both", got)
+	}
+	syncTest(t, carol)
+	if got := remoteTombstones(); len(got) != 0 {
+		t.Fatalf("tombstones after every machine synced = %+v, want none", got)
+	}
+	syncTest(t, alice)
+	for _, app := range []*App{alice, bob, carol} {
+		bundle, err := app.LoadProject("api", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := GetSecret(bundle, "", "GONE"); ok {
+			t.Fatalf("%s: GONE came back", app.config.Machine.Name)
+		}
+		if testSecret(t, app, "api", "KEEP") != "k" {
+			t.Fatalf("%s: KEEP lost", app.config.Machine.Name)
+		}
+	}
+	if bundle, _ := alice.LoadProject("api", ""); len(bundle.Tombstones) != 0 {
+		t.Fatalf("alice kept tombstones %+v", bundle.Tombstones)
+	}
+}
+
+// A rotated key is endorsed by the old one, so a peer that trusted the old
+// key trusts the new one on its next sync instead of quarantining the
+// rotating machine's bundles and holding its key as pending.
+func TestRotateKeyMovesTrustToSuccessor(t *testing.T) {
+	fake, srv := newFakeGist(t)
+	alice := newGistTestApp(t, srv, "alice")
+	if err := alice.Link(LinkOptions{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	bob := newGistTestApp(t, srv, "bob")
+	if err := bob.Link(LinkOptions{Token: "token", GistID: fake.gistID()}); err != nil {
+		t.Fatal(err)
+	}
+	trustEachOther(t, alice, bob)
+	setTestSecret(t, alice, "api", "TOKEN", "one")
+	syncTest(t, alice)
+	syncTest(t, bob)
+
+	oldKey := alice.config.Machine.PublicKey
+	newKey, err := alice.RotateKey(SyncOptions{Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestSecret(t, alice, "api", "TOKEN", "two")
+	syncTest(t, alice)
+
+	report := syncTest(t, bob)
+	if len(report.NewPending) != 0 || len(report.Warnings) != 0 {
+		t.Fatalf("bob: new pending = %v, warnings = %v", report.NewPending, report.Warnings)
+	}
+	if !bob.isTrustedRecipient(newKey) || bob.isTrustedRecipient(oldKey) || !bob.isDeniedRecipient(oldKey) {
+		t.Fatal("bob did not move trust from alice's old key to the new one")
+	}
+	if got := testSecret(t, bob, "api", "TOKEN"); got != "two" {
+		t.Fatalf("bob TOKEN = %q, want two", got)
+	}
+	if record := bob.config.Machines[bob.machineIndex(alice.config.Machine.ID)]; record.PublicKey != newKey || record.SigningKey != alice.config.Machine.SigningKey {
+		t.Fatalf("bob's record of alice = %+v", record)
+	}
+
+	setTestSecret(t, bob, "api", "TOKEN", "three")
+	syncTest(t, bob)
+	syncTest(t, alice)
+	if got := testSecret(t, alice, "api", "TOKEN"); got != "three" {
+		t.Fatalf("alice TOKEN = %q, want three", got)
+	}
+}
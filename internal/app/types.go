@@ -1,6 +1,10 @@
 package app
 
-import "time"
+import (
+	"time"
+
+	"filippo.io/age"
+)
 
 const (
 	configVersion      = 1
@@ -10,24 +14,39 @@ const (
 )
 
 type Config struct {
-	Version      int               `json:"version"`
-	CreatedAt    string            `json:"created_at"`
-	UpdatedAt    string            `json:"updated_at"`
-	Machine      MachineConfig     `json:"machine"`
-	KeyStorage   string            `json:"key_storage"`
-	KeyFile      string            `json:"key_file,omitempty"`
-	Projects     map[string]string `json:"projects"`
-	PathProjects map[string]string `json:"path_projects"`
-	Recipients   []string          `json:"recipients"`
-	Gist         GistConfig        `json:"gist"`
-	Prefs        Preferences       `json:"prefs"`
+	Version        int                         `json:"version"`
+	CreatedAt      string                      `json:"created_at"`
+	UpdatedAt      string                      `json:"updated_at"`
+	Machine        MachineConfig               `json:"machine"`
+	KeyStorage     string                      `json:"key_storage"`
+	KeyFile        string                      `json:"key_file,omitempty"`
+	Projects       map[string]string           `json:"projects"`
+	PathProjects   map[string]string           `json:"path_projects"`
+	Recipients     []string                    `json:"recipients"`
+	Pending        []PendingRecipient          `json:"pending_recipients,omitempty"`
+	Denied         []string                    `json:"denied_recipients,omitempty"`
+	Signers        []Signer                    `json:"signers,omitempty"`
+	PendingSigners []Signer                    `json:"pending_signers,omitempty"`
+	Machines       []MachineRecord             `json:"machines,omitempty"`
+	Revocations    []Revocation                `json:"revocations,omitempty"`
+	Remote         RemoteConfig                `json:"remote"`
+	ProjectSync    map[string]ProjectSyncState `json:"project_sync,omitempty"`
+	GitHub         GitHubConfig                `json:"github,omitempty"`
+	LegacyGist     *GistConfig                 `json:"gist,omitempty"`
+	Prefs          Preferences                 `json:"prefs"`
 }
 
 type MachineConfig struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	PublicKey string `json:"public_key"`
-	AddedAt   string `json:"added_at"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	PublicKey  string `json:"public_key"`
+	SigningKey string `json:"signing_key,omitempty"`
+	AddedAt    string `json:"added_at"`
+}
+
+type PendingRecipient struct {
+	PublicKey   string `json:"public_key"`
+	FirstSeenAt string `json:"first_seen_at"`
 }
 
 type GistConfig struct {
@@ -36,22 +55,117 @@ type GistConfig struct {
 	LastSyncedAt string `json:"last_synced_at,omitempty"`
 }
 
+type RemoteConfig struct {
+	Type         string `json:"type,omitempty"`
+	ID           string `json:"id,omitempty"`
+	Owner        string `json:"owner,omitempty"`
+	Path         string `json:"path,omitempty"`
+	URL          string `json:"url,omitempty"`
+	Bucket       string `json:"bucket,omitempty"`
+	Prefix       string `json:"prefix,omitempty"`
+	Region       string `json:"region,omitempty"`
+	LastSyncedAt string `json:"last_synced_at,omitempty"`
+}
+
+type GitHubConfig struct {
+	APIURL   string `json:"api_url,omitempty"`
+	OAuthURL string `json:"oauth_url,omitempty"`
+	Proxy    string `json:"proxy,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+}
+
 type Preferences struct {
 	ExportFormat string `json:"export_format"`
 }
 
 type ProjectBundle struct {
-	Project string   `json:"project"`
-	Path    string   `json:"path"`
-	Secrets []Secret `json:"secrets"`
+	Project           string      `json:"project"`
+	Path              string      `json:"path"`
+	Includes          []string    `json:"includes,omitempty"`
+	IncludesUpdatedAt string      `json:"includes_updated_at,omitempty"`
+	Secrets           []Secret    `json:"secrets"`
+	Tombstones        []Tombstone `json:"tombstones,omitempty"`
 }
 
 type Secret struct {
-	Key       string `json:"key"`
+	Env         string          `json:"env,omitempty"`
+	Key         string          `json:"key"`
+	Value       string          `json:"value"`
+	Group       string          `json:"group"`
+	Description string          `json:"description,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	Source      string          `json:"source,omitempty"`
+	ExpiresAt   string          `json:"expires_at,omitempty"`
+	Expand      bool            `json:"expand,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+	UpdatedAt   string          `json:"updated_at"`
+	UpdatedBy   string          `json:"updated_by,omitempty"`
+	History     []SecretVersion `json:"history,omitempty"`
+}
+
+type SecretVersion struct {
 	Value     string `json:"value"`
-	Group     string `json:"group"`
-	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+type Tombstone struct {
+	Env       string   `json:"env,omitempty"`
+	Key       string   `json:"key"`
+	DeletedAt string   `json:"deleted_at"`
+	MachineID string   `json:"machine_id"`
+	SeenBy    []string `json:"seen_by,omitempty"`
+}
+
+type SyncConflict struct {
+	Project string
+	Env     string
+	Key     string
+	Kept    string
+}
+
+type QuarantinedBlob struct {
+	Project string
+	Reason  string
+	Path    string
+}
+
+type SyncChange struct {
+	Project string
+	Env     string
+	Key     string
+	Action  string
+	Value   string
+	Detail  string
+}
+
+type ProjectError struct {
+	Project string
+	Err     error
+}
+
+func (e ProjectError) Error() string {
+	return e.Project + ": " + e.Err.Error()
+}
+
+type SyncReport struct {
+	Pulled      []string
+	Skipped     []string
+	Failed      []ProjectError
+	Conflicts   []SyncConflict
+	NewPending  []string
+	Warnings    []string
+	Quarantined []QuarantinedBlob
+	Plan        []SyncChange
+}
+
+type SyncOptions struct {
+	Token         string
+	AllowUnsigned bool
+	DryRun        bool
+	// signWith signs what the sync writes with another identity than the
+	// one it decrypts with; rotate-key uses it to hand over to the new key.
+	signWith *age.X25519Identity
 }
 
 func nowRFC3339() string {
@@ -0,0 +1,253 @@
+package app
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+const webdavCredentialUser = "webdav_"
+
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/><d:resourcetype/></d:prop></d:propfind>`
+
+type webdavBackend struct {
+	client   *http.Client
+	base     *url.URL
+	user     string
+	password string
+	etags    map[string]string
+	missing  bool
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ETag         string `xml:"getetag"`
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func (a *App) newWebDAVBackend(remote RemoteConfig) (*webdavBackend, error) {
+	base, err := url.Parse(strings.TrimSpace(remote.URL))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid webdav url %q (run `veil link --backend webdav --url https://...`)", remote.URL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	user, password, err := loadWebDAVCredentials(base.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &webdavBackend{
+		client:   newRemoteClient(),
+		base:     base,
+		user:     user,
+		password: password,
+		etags:    map[string]string{},
+	}, nil
+}
+
+func loadWebDAVCredentials(host string) (string, string, error) {
+	user := strings.TrimSpace(os.Getenv("VEIL_WEBDAV_USER"))
+	password := os.Getenv("VEIL_WEBDAV_PASSWORD")
+	if user != "" {
+		return user, password, nil
+	}
+	stored, err := keyring.Get(serviceName, webdavCredentialUser+host)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("read webdav credentials from keychain: %w", err)
+	}
+	user, password, _ = strings.Cut(stored, ":")
+	return user, password, nil
+}
+
+func (a *App) StoreWebDAVCredentials(rawURL, user, password string) error {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid webdav url %q", rawURL)
+	}
+	user = strings.TrimSpace(user)
+	if user == "" || strings.Contains(user, ":") {
+		return errors.New("webdav user must be non-empty and cannot contain ':'")
+	}
+	if err := keyring.Set(serviceName, webdavCredentialUser+parsed.Host, user+":"+password); err != nil {
+		return fmt.Errorf("store webdav credentials in keychain: %w", err)
+	}
+	return nil
+}
+
+func (b *webdavBackend) Type() string {
+	return remoteWebDAV
+}
+
+func (b *webdavBackend) List() ([]string, error) {
+	resp, err := b.do("PROPFIND", "", []byte(webdavPropfind), map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		b.missing = true
+		return []string{}, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("webdav list failed: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var status webdavMultistatus
+	if err := xml.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("decode webdav listing: %w", err)
+	}
+	names := []string{}
+	for _, response := range status.Responses {
+		href, err := url.PathUnescape(response.Href)
+		if err != nil {
+			continue
+		}
+		if parsed, err := url.Parse(href); err == nil {
+			href = parsed.Path
+		}
+		if strings.TrimSuffix(href, "/") == strings.TrimSuffix(b.base.Path, "/") {
+			continue
+		}
+		collection := false
+		etag := ""
+		for _, propstat := range response.Propstat {
+			if propstat.Prop.ResourceType.Collection != nil {
+				collection = true
+			}
+			if propstat.Prop.ETag != "" {
+				etag = propstat.Prop.ETag
+			}
+		}
+		name := path.Base(href)
+		if collection || name == "" || strings.HasPrefix(name, ".") {
+			continue
+		}
+		b.etags[name] = etag
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *webdavBackend) Fetch(name string) (string, error) {
+	resp, err := b.do(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		b.etags[name] = ""
+		return "", fmt.Errorf("%s: %w", name, errRemoteMissing)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("webdav get %s failed: %s %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	b.etags[name] = resp.Header.Get("ETag")
+	return string(body), nil
+}
+
+// Same contract as the S3 backend: every PUT is conditional on the ETag seen
+// while reading, so a concurrent writer surfaces as errRemoteChanged.
+func (b *webdavBackend) Put(files map[string]string) error {
+	if b.missing {
+		resp, err := b.do("MKCOL", "", nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("webdav create collection failed: %s", resp.Status)
+		}
+		b.missing = false
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		headers := map[string]string{"Content-Type": "application/octet-stream"}
+		if etag, seen := b.etags[name]; seen && etag != "" {
+			headers["If-Match"] = etag
+		} else {
+			headers["If-None-Match"] = "*"
+		}
+		resp, err := b.do(http.MethodPut, name, []byte(files[name]), headers)
+		if err != nil {
+			return err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusPreconditionFailed:
+			return fmt.Errorf("%s: %w", name, errRemoteChanged)
+		case resp.StatusCode >= 300:
+			return fmt.Errorf("webdav put %s failed: %s %s", name, resp.Status, strings.TrimSpace(string(body)))
+		}
+		b.etags[name] = resp.Header.Get("ETag")
+	}
+	return nil
+}
+
+func (b *webdavBackend) Recipients() ([]string, error) {
+	return remoteRecipients(b)
+}
+
+func (b *webdavBackend) do(method, name string, body []byte, headers map[string]string) (*http.Response, error) {
+	target := b.base.ResolveReference(&url.URL{Path: name})
+	if name == "" {
+		target = b.base
+	}
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if b.user != "" {
+		req.SetBasicAuth(b.user, b.password)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, errors.New("webdav authentication failed (run `veil link --backend webdav --user NAME` to store credentials)")
+	}
+	return resp, nil
+}
@@ -0,0 +1,234 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeWebDAV serves one collection with the PROPFIND, MKCOL, GET and
+// conditional PUT the backend uses, behind basic auth.
+type fakeWebDAV struct {
+	mu         sync.Mutex
+	collection string
+	exists     bool
+	files      map[string]string
+	etags      map[string]string
+	version    int
+	mkcols     int
+}
+
+func newFakeWebDAV(t *testing.T, collection string) (*fakeWebDAV, *httptest.Server) {
+	t.Helper()
+	fake := &fakeWebDAV{collection: collection, files: map[string]string{}, etags: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, srv
+}
+
+func (f *fakeWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, f.collection)
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "PROPFIND" && name == "":
+		if !f.exists {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Depth") != "1" {
+			http.Error(w, "depth", http.StatusBadRequest)
+			return
+		}
+		f.propfind(w)
+	case r.Method == "MKCOL" && name == "":
+		if f.exists {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		f.mkcols++
+		f.exists = true
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet:
+		content, ok := f.files[name]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etags[name])
+		io.WriteString(w, content)
+	case r.Method == http.MethodPut:
+		if !f.exists {
+			http.Error(w, "conflict", http.StatusConflict)
+			return
+		}
+		_, exists := f.files[name]
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || match != f.etags[name]) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.version++
+		f.files[name] = string(body)
+		f.etags[name] = fmt.Sprintf(`"e%d"`, f.version)
+		w.Header().Set("ETag", f.etags[name])
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+// propfind answers the way Nextcloud does: the collection itself first,
+// percent-encoded hrefs, and subcollections marked in resourcetype.
+func (f *fakeWebDAV) propfind(w http.ResponseWriter) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+	entry := func(href, etag string, collection bool) {
+		resourceType := ""
+		if collection {
+			resourceType = "<d:collection/>"
+		}
+		fmt.Fprintf(&b, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:getetag>%s</d:getetag><d:resourcetype>%s</d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, href, etag, resourceType)
+	}
+	entry(f.collection, "", true)
+	entry(f.collection+"archive/", "", true)
+	names := make([]string, 0, len(f.files))
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry(path.Join(f.collection, url.PathEscape(name)), f.etags[name], false)
+	}
+	b.WriteString(`</d:multistatus>`)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
+
+func (f *fakeWebDAV) put(name, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exists = true
+	f.version++
+	f.files[name] = content
+	f.etags[name] = fmt.Sprintf(`"e%d"`, f.version)
+}
+
+func newTestWebDAVBackend(t *testing.T, srv *httptest.Server, collection string) *webdavBackend {
+	t.Helper()
+	t.Setenv("VEIL_WEBDAV_USER", "alice")
+	t.Setenv("VEIL_WEBDAV_PASSWORD", "secret")
+	backend, err := (&App{}).newWebDAVBackend(RemoteConfig{Type: remoteWebDAV, URL: srv.URL + collection})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestWebDAVListFetchPut(t *testing.T) {
+	fake, srv := newFakeWebDAV(t, "/dav/veil/")
+	backend := newTestWebDAVBackend(t, srv, "/dav/veil")
+
+	names, err := backend.List()
+	if err != nil || len(names) != 0 {
+		t.Fatalf("List of a missing collection = %v, %v", names, err)
+	}
+	if err := backend.Put(map[string]string{"app.json.age": "app", "my app.json.age": "spaced"}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.mkcols != 1 || fake.files["app.json.age"] != "app" || fake.files["my app.json.age"] != "spaced" {
+		t.Fatalf("after first Put: mkcols = %d, files = %v", fake.mkcols, fake.files)
+	}
+	fake.put(".veil-lock", "x")
+
+	other := newTestWebDAVBackend(t, srv, "/dav/veil")
+	names, err = other.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "app.json.age,my app.json.age" {
+		t.Fatalf("List = %q, want the two files without the subcollection or dotfile", names)
+	}
+	if other.etags["my app.json.age"] != fake.etags["my app.json.age"] {
+		t.Fatalf("etag from listing = %q, want %q", other.etags["my app.json.age"], fake.etags["my app.json.age"])
+	}
+	content, err := other.Fetch("my app.json.age")
+	if err != nil || content != "spaced" {
+		t.Fatalf("Fetch = %q, %v", content, err)
+	}
+	if _, err := other.Fetch("missing.json.age"); !errors.Is(err, errRemoteMissing) {
+		t.Fatalf("Fetch missing = %v, want errRemoteMissing", err)
+	}
+	// The ETags returned by the PUTs let the first backend write again.
+	if err := backend.Put(map[string]string{"app.json.age": "app v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.files["app.json.age"] != "app v2" {
+		t.Fatalf("app.json.age = %q", fake.files["app.json.age"])
+	}
+}
+
+func TestWebDAVPutRejectsStaleETag(t *testing.T) {
+	fake, srv := newFakeWebDAV(t, "/dav/veil/")
+	fake.put("app.json.age", "app")
+
+	first := newTestWebDAVBackend(t, srv, "/dav/veil/")
+	second := newTestWebDAVBackend(t, srv, "/dav/veil/")
+	for _, backend := range []*webdavBackend{first, second} {
+		if _, err := backend.List(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := first.Put(map[string]string{"app.json.age": "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Put(map[string]string{"app.json.age": "second"}); !errors.Is(err, errRemoteChanged) {
+		t.Fatalf("stale If-Match Put = %v, want errRemoteChanged", err)
+	}
+	if err := second.Put(map[string]string{"created.json.age": "second"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Put(map[string]string{"created.json.age": "first"}); !errors.Is(err, errRemoteChanged) {
+		t.Fatalf("If-None-Match Put over a new file = %v, want errRemoteChanged", err)
+	}
+	if fake.files["app.json.age"] != "first" || fake.files["created.json.age"] != "second" {
+		t.Fatalf("files = %v", fake.files)
+	}
+}
+
+func TestWebDAVRejectsBadCredentials(t *testing.T) {
+	_, srv := newFakeWebDAV(t, "/dav/veil/")
+	backend := newTestWebDAVBackend(t, srv, "/dav/veil/")
+	backend.password = "wrong"
+	if _, err := backend.List(); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("List with a wrong password = %v", err)
+	}
+}
+
+func TestWebDAVClientHasTimeout(t *testing.T) {
+	_, srv := newFakeWebDAV(t, "/dav/veil/")
+	if backend := newTestWebDAVBackend(t, srv, "/dav/veil/"); backend.client.Timeout == 0 {
+		t.Fatal("webdav backend uses a client without a timeout")
+	}
+}
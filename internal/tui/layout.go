@@ -3,6 +3,7 @@ package tui
 import (
 	"strings"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/lipgloss"
 )
 
@@ -20,7 +21,7 @@ const (
 	maxReadableWidth  = 120
 	minTableHeight    = 3
 	maxContentHeight  = 20
-	projectInfoHeight = 4
+	projectInfoHeight = 5
 )
 
 func (m *model) relayout() {
@@ -170,6 +171,7 @@ func (m model) pageLabel() string {
 
 func (m *model) resetInputForPage() {
 	m.input.SetValue("")
+	m.input.EchoMode = textinput.EchoNormal
 	switch m.page {
 	case pageHome:
 		m.input.Prompt = "key=value> "
@@ -1,5 +1,7 @@
 package tui
 
+import "strings"
+
 type activeModal struct {
 	Title  string
 	Detail string
@@ -12,13 +14,26 @@ func (m model) currentModal() (activeModal, bool) {
 	case modeAddValue:
 		return activeModal{Title: "Add Secret Value", Detail: "Enter the secret value"}, true
 	case modeEditValue:
-		return activeModal{Title: "Edit Secret Value", Detail: "Update value and confirm"}, true
+		return activeModal{Title: "Edit Secret", Detail: "Editing " + editFieldNames[m.editField] + " · [tab] next field · [enter] save all"}, true
 	case modeFilter:
-		return activeModal{Title: "Filter", Detail: "Filter by key or group"}, true
+		return activeModal{Title: "Filter", Detail: "Filter by key, group, description, tag or source"}, true
 	case modeImportPath:
 		return activeModal{Title: "Import .env", Detail: "Enter path to .env file"}, true
 	case modeExportPath:
 		return activeModal{Title: "Export", Detail: "Enter export file path"}, true
+	case modeKeyStorage:
+		return activeModal{Title: "Key Storage", Detail: "Move the identity to file, keychain or passphrase"}, true
+	case modeKeyPassphrase:
+		return activeModal{Title: "Key Passphrase", Detail: "Choose a passphrase to protect the identity"}, true
+	case modeKeyPassphraseConfirm:
+		return activeModal{Title: "Key Passphrase", Detail: "Enter the same passphrase again"}, true
+	case modeEnvSelect:
+		envs := append([]string{"base"}, environments(m.bundle)...)
+		return activeModal{Title: "Environment", Detail: "Existing: " + strings.Join(envs, ", ") + " · a new name starts an empty layer"}, true
+	case modeHistory:
+		return activeModal{Title: "History", Detail: m.historyKey + " in " + m.historyLayer + ", newest first"}, true
+	case modeSyncPreview:
+		return activeModal{Title: "Sync Preview", Detail: "Nothing is written until you confirm"}, true
 	default:
 		return activeModal{}, false
 	}
@@ -29,6 +29,12 @@ const (
 	modeImportPath
 	modeExportPath
 	modePageSelect
+	modeKeyStorage
+	modeKeyPassphrase
+	modeKeyPassphraseConfirm
+	modeSyncPreview
+	modeEnvSelect
+	modeHistory
 )
 
 type model struct {
@@ -42,13 +48,23 @@ type model struct {
 	input         textinput.Model
 	status        string
 	current       string
+	env           string
 	bundle        *ProjectBundle
+	resolved      []Secret
 	filterQuery   string
 	pendingKey    string
 	pendingValue  string
+	editField     int
+	editValues    []string
 	revealKey     string
 	pendingReveal string
 	pendingDelete string
+	passphrase    string
+	syncPlan      SyncResult
+	history       []SecretVersion
+	historyKey    string
+	historyLayer  string
+	historyCursor int
 	needsInit     bool
 	styles        styles
 }
@@ -58,6 +74,7 @@ func newModel(svc Service) model {
 		{Title: "Group", Width: 14},
 		{Title: "Key", Width: 36},
 		{Title: "Value", Width: 40},
+		{Title: "Layer", Width: 14},
 	}
 	tbl := table.New(table.WithColumns(columns), table.WithRows([]table.Row{}), table.WithFocused(true), table.WithHeight(12))
 
@@ -107,7 +124,7 @@ func (m *model) load() {
 	}
 	projects, err := m.svc.ListProjects()
 	if err != nil {
-		m.status = err.Error()
+		m.status = errorStatus(err)
 		return
 	}
 	m.projects = projects
@@ -132,10 +149,24 @@ func (m *model) loadBundle() {
 	}
 	bundle, err := m.svc.LoadProject(m.current, projectPath)
 	if err != nil {
-		m.status = err.Error()
+		m.status = errorStatus(err)
 		return
 	}
 	m.bundle = bundle
+	m.resolve()
+}
+
+// resolve recomputes the view through included bundles and the base layer;
+// it reads the included bundles from disk, so the table caches the result.
+func (m *model) resolve() {
+	m.resolved = nil
+	if m.bundle != nil {
+		resolved, err := m.svc.ResolveSecrets(m.bundle, m.env)
+		if err != nil {
+			m.status = errorStatus(err)
+		}
+		m.resolved = resolved
+	}
 	m.refreshTable()
 }
 
@@ -144,7 +175,7 @@ func (m *model) refreshTable() {
 		m.projectTable.SetRows([]table.Row{})
 		return
 	}
-	secrets := append([]Secret(nil), m.bundle.Secrets...)
+	secrets := append([]Secret(nil), m.resolved...)
 	sort.Slice(secrets, func(i, j int) bool {
 		if secrets[i].Group == secrets[j].Group {
 			return secrets[i].Key < secrets[j].Key
@@ -154,14 +185,15 @@ func (m *model) refreshTable() {
 	rows := make([]table.Row, 0, len(secrets))
 	query := strings.ToLower(strings.TrimSpace(m.filterQuery))
 	for _, secret := range secrets {
-		if query != "" && !strings.Contains(strings.ToLower(secret.Key+" "+secret.Group), query) {
+		haystack := strings.Join([]string{secret.Key, secret.Group, secret.Description, strings.Join(secret.Tags, " "), secret.Source}, " ")
+		if query != "" && !strings.Contains(strings.ToLower(haystack), query) {
 			continue
 		}
 		value := maskValue(secret.Value)
 		if m.revealKey == secret.Key {
 			value = secret.Value
 		}
-		rows = append(rows, table.Row{secret.Group, secret.Key, value})
+		rows = append(rows, table.Row{secret.Group, secret.Key, value, secret.Layer})
 	}
 	m.projectTable.SetRows(rows)
 }
@@ -180,7 +212,7 @@ func (m *model) ensureCurrentBundle() error {
 	}
 	m.current = project
 	m.bundle = bundle
-	m.refreshTable()
+	m.resolve()
 	return nil
 }
 
@@ -189,6 +221,12 @@ func (m model) renderInputPanel() string {
 	if !ok {
 		return ""
 	}
+	if m.mode == modeSyncPreview {
+		return m.renderInputBlock(modal.Title, modal.Detail, m.renderSyncPlan())
+	}
+	if m.mode == modeHistory {
+		return m.renderInputBlock(modal.Title, modal.Detail, m.renderHistory())
+	}
 	return m.renderInputBlock(modal.Title, modal.Detail, m.input.View())
 }
 
@@ -197,27 +235,31 @@ func (m *model) resizeProjectColumns(tableWidth int) {
 		return
 	}
 
-	available := max(24, tableWidth-4)
+	available := max(32, tableWidth-5)
 	groupWidth := 10
 	keyWidth := 18
 	valueWidth := 16
-	baseTotal := groupWidth + keyWidth + valueWidth
+	layerWidth := 10
+	baseTotal := groupWidth + keyWidth + valueWidth + layerWidth
 
 	if available > baseTotal {
 		extra := available - baseTotal
-		keyExtra := extra * 55 / 100
-		valueExtra := extra * 35 / 100
-		groupExtra := extra - keyExtra - valueExtra
+		keyExtra := extra * 45 / 100
+		valueExtra := extra * 30 / 100
+		layerExtra := extra * 10 / 100
+		groupExtra := extra - keyExtra - valueExtra - layerExtra
 		groupWidth += groupExtra
 		keyWidth += keyExtra
 		valueWidth += valueExtra
+		layerWidth += layerExtra
 	} else if available < baseTotal {
-		groupWidth = max(8, available/5)
-		remaining := max(12, available-groupWidth)
+		groupWidth = max(8, available/6)
+		layerWidth = max(8, available/6)
+		remaining := max(20, available-groupWidth-layerWidth)
 		keyWidth = max(12, remaining/2)
-		valueWidth = max(8, available-groupWidth-keyWidth)
-		if groupWidth+keyWidth+valueWidth > available {
-			over := groupWidth + keyWidth + valueWidth - available
+		valueWidth = max(8, available-groupWidth-layerWidth-keyWidth)
+		if total := groupWidth + keyWidth + valueWidth + layerWidth; total > available {
+			over := total - available
 			if keyWidth-over >= 12 {
 				keyWidth -= over
 			} else {
@@ -230,9 +272,25 @@ func (m *model) resizeProjectColumns(tableWidth int) {
 		{Title: "Group", Width: groupWidth},
 		{Title: "Key", Width: keyWidth},
 		{Title: "Value", Width: valueWidth},
+		{Title: "Layer", Width: layerWidth},
 	})
 }
 
+// selectedSecret resolves the highlighted row in the current view, so keys
+// inherited from the base layer or an included bundle can be overridden.
+func (m model) selectedSecret() (Secret, bool) {
+	row := m.projectTable.SelectedRow()
+	if m.bundle == nil || len(row) < 2 {
+		return Secret{}, false
+	}
+	for _, secret := range m.resolved {
+		if secret.Key == row[1] {
+			return secret, true
+		}
+	}
+	return Secret{}, false
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
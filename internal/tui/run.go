@@ -11,5 +11,9 @@ func Run(svc Service) error {
 }
 
 func RunTUI(app *appcore.App) error {
-	return Run(newTUIService(app))
+	m := newModel(newTUIService(app))
+	// Passphrase prompts would fight Bubble Tea for the terminal once it starts.
+	app.DisablePrompts()
+	_, err := tea.NewProgram(m).Run()
+	return err
 }
@@ -33,22 +33,32 @@ func detectGroup(key string) string {
 	return "General"
 }
 
-func upsertSecret(bundle *ProjectBundle, key, value, group string) (created bool) {
+func upsertSecret(bundle *ProjectBundle, env, key, value, group string) (created bool) {
 	now := nowRFC3339()
+	clearTombstone(bundle, env, key)
 	for i := range bundle.Secrets {
-		if bundle.Secrets[i].Key == key {
+		if bundle.Secrets[i].Env == env && bundle.Secrets[i].Key == key {
+			if bundle.Secrets[i].Value != value {
+				recordVersion(&bundle.Secrets[i])
+			}
 			bundle.Secrets[i].Value = value
 			if group != "" {
 				bundle.Secrets[i].Group = group
 			}
 			bundle.Secrets[i].UpdatedAt = now
+			bundle.Secrets[i].UpdatedBy = ""
 			return false
 		}
 	}
 	if group == "" {
-		group = detectGroup(key)
+		if inherited, ok := getSecret(bundle, "", key); ok && env != "" {
+			group = inherited.Group
+		} else {
+			group = detectGroup(key)
+		}
 	}
 	bundle.Secrets = append(bundle.Secrets, Secret{
+		Env:       env,
 		Key:       key,
 		Value:     value,
 		Group:     group,
@@ -58,25 +68,178 @@ func upsertSecret(bundle *ProjectBundle, key, value, group string) (created bool
 	return true
 }
 
-func removeSecret(bundle *ProjectBundle, key string) bool {
+const maxSecretHistory = 20
+
+// recordVersion and secretVersions mirror the appcore helpers: history is
+// stored oldest first and listed newest first with the current value as 0.
+func recordVersion(secret *Secret) {
+	history := append(append([]SecretVersion(nil), secret.History...), SecretVersion{Value: secret.Value, UpdatedAt: secret.UpdatedAt, UpdatedBy: secret.UpdatedBy})
+	if len(history) > maxSecretHistory {
+		history = history[len(history)-maxSecretHistory:]
+	}
+	secret.History = history
+}
+
+func secretVersions(secret Secret) []SecretVersion {
+	versions := []SecretVersion{{Value: secret.Value, UpdatedAt: secret.UpdatedAt, UpdatedBy: secret.UpdatedBy}}
+	for i := len(secret.History) - 1; i >= 0; i-- {
+		versions = append(versions, secret.History[i])
+	}
+	return versions
+}
+
+func removeSecret(bundle *ProjectBundle, env, key string) bool {
 	for i := range bundle.Secrets {
-		if bundle.Secrets[i].Key == key {
+		if bundle.Secrets[i].Env == env && bundle.Secrets[i].Key == key {
 			bundle.Secrets = append(bundle.Secrets[:i], bundle.Secrets[i+1:]...)
+			clearTombstone(bundle, env, key)
+			bundle.Tombstones = append(bundle.Tombstones, Tombstone{Env: env, Key: key, DeletedAt: nowRFC3339()})
 			return true
 		}
 	}
 	return false
 }
 
-func getSecret(bundle *ProjectBundle, key string) (Secret, bool) {
+func clearTombstone(bundle *ProjectBundle, env, key string) {
+	for i := range bundle.Tombstones {
+		if bundle.Tombstones[i].Env == env && bundle.Tombstones[i].Key == key {
+			bundle.Tombstones = append(bundle.Tombstones[:i], bundle.Tombstones[i+1:]...)
+			return
+		}
+	}
+}
+
+func getSecret(bundle *ProjectBundle, env, key string) (Secret, bool) {
 	for _, secret := range bundle.Secrets {
-		if secret.Key == key {
+		if secret.Env == env && secret.Key == key {
 			return secret, true
 		}
 	}
 	return Secret{}, false
 }
 
+// layerName mirrors appcore.LayerName, the label of the project's own layer
+// in the Layer column.
+func layerName(project, env string) string {
+	if env == "" {
+		return project
+	}
+	return project + "/" + env
+}
+
+func environments(bundle *ProjectBundle) []string {
+	seen := map[string]bool{}
+	envs := []string{}
+	for _, secret := range bundle.Secrets {
+		if secret.Env != "" && !seen[secret.Env] {
+			seen[secret.Env] = true
+			envs = append(envs, secret.Env)
+		}
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+func envLabel(env string) string {
+	if env == "" {
+		return "base"
+	}
+	return env
+}
+
+func metadataOf(secret Secret) SecretMetadata {
+	return SecretMetadata{Description: secret.Description, Tags: secret.Tags, Source: secret.Source, ExpiresAt: secret.ExpiresAt}
+}
+
+// setSecretMetadata mirrors appcore.SetSecretMetadata for metadata the service
+// has already normalized.
+func setSecretMetadata(bundle *ProjectBundle, env, key string, meta SecretMetadata) bool {
+	for i := range bundle.Secrets {
+		secret := &bundle.Secrets[i]
+		if secret.Env != env || secret.Key != key {
+			continue
+		}
+		current := metadataOf(*secret)
+		if current.Description == meta.Description && current.Source == meta.Source && current.ExpiresAt == meta.ExpiresAt && strings.Join(current.Tags, ",") == strings.Join(meta.Tags, ",") {
+			return false
+		}
+		secret.Description = meta.Description
+		secret.Tags = meta.Tags
+		secret.Source = meta.Source
+		secret.ExpiresAt = meta.ExpiresAt
+		secret.UpdatedAt = nowRFC3339()
+		secret.UpdatedBy = ""
+		return true
+	}
+	return false
+}
+
+// importedMetadata mirrors appcore.ImportSecret: fields the import carries
+// replace the stored ones, the rest are kept.
+func importedMetadata(existing, imported Secret) SecretMetadata {
+	meta := metadataOf(existing)
+	if imported.Description != "" {
+		meta.Description = imported.Description
+	}
+	if len(imported.Tags) > 0 {
+		meta.Tags = imported.Tags
+	}
+	if imported.Source != "" {
+		meta.Source = imported.Source
+	}
+	if imported.ExpiresAt != "" {
+		meta.ExpiresAt = imported.ExpiresAt
+	}
+	return meta
+}
+
+// setSecretExpand mirrors the part of appcore.ImportSecret that carries an
+// imported expand mark.
+func setSecretExpand(bundle *ProjectBundle, env, key string) {
+	for i := range bundle.Secrets {
+		secret := &bundle.Secrets[i]
+		if secret.Env == env && secret.Key == key && !secret.Expand {
+			secret.Expand = true
+			secret.UpdatedAt = nowRFC3339()
+			secret.UpdatedBy = ""
+			return
+		}
+	}
+}
+
+// expiryInput shows a date-only expiry the way it was most likely typed.
+func expiryInput(expiresAt string) string {
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return expiresAt
+	}
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format("2006-01-02")
+	}
+	return expiresAt
+}
+
+func secretDetails(secret Secret, now time.Time) string {
+	var details []string
+	if secret.Description != "" {
+		details = append(details, secret.Description)
+	}
+	if len(secret.Tags) > 0 {
+		details = append(details, "tags: "+strings.Join(secret.Tags, ", "))
+	}
+	if secret.Source != "" {
+		details = append(details, "source: "+secret.Source)
+	}
+	if t, err := time.Parse(time.RFC3339, secret.ExpiresAt); err == nil {
+		if now.Before(t) {
+			details = append(details, "expires "+t.Format("2006-01-02"))
+		} else {
+			details = append(details, "expired "+t.Format("2006-01-02"))
+		}
+	}
+	return strings.Join(details, " · ")
+}
+
 func maskValue(value string) string {
 	if value == "" {
 		return ""
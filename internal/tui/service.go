@@ -7,30 +7,88 @@ type ProjectSummary struct {
 }
 
 type Secret struct {
-	Key       string
+	Env         string
+	Key         string
+	Value       string
+	Group       string
+	Description string
+	Tags        []string
+	Source      string
+	ExpiresAt   string
+	Expand      bool
+	CreatedAt   string
+	UpdatedAt   string
+	UpdatedBy   string
+	History     []SecretVersion
+	Layer       string
+}
+
+type SecretMetadata struct {
+	Description string
+	Tags        []string
+	Source      string
+	ExpiresAt   string
+}
+
+type SecretVersion struct {
 	Value     string
-	Group     string
-	CreatedAt string
 	UpdatedAt string
+	UpdatedBy string
+}
+
+type Tombstone struct {
+	Env       string
+	Key       string
+	DeletedAt string
+	MachineID string
+	SeenBy    []string
 }
 
 type ProjectBundle struct {
-	Project string
-	Path    string
-	Secrets []Secret
+	Project           string
+	Path              string
+	Includes          []string
+	IncludesUpdatedAt string
+	Secrets           []Secret
+	Tombstones        []Tombstone
 }
 
 type SettingsView struct {
-	GistID       string
+	Remote       string
 	LastSyncedAt string
+	PendingPush  int
 	MachineName  string
 	KeyStorage   string
 	ExportFormat string
+	Pending      []string
+	Machines     []MachineView
+}
+
+type MachineView struct {
+	ID         string
+	Name       string
+	PublicKey  string
+	Status     string
+	AddedAt    string
+	LastSeenAt string
+	Current    bool
+}
+
+type SyncChange struct {
+	Project string
+	Key     string
+	Action  string
+	Value   string
+	Detail  string
 }
 
-type EnvPair struct {
-	Key   string
-	Value string
+type SyncResult struct {
+	Pulled      int
+	Conflicts   []string
+	Quarantined int
+	Failed      []string
+	Warnings    []string
+	Plan        []SyncChange
 }
 
 type Service interface {
@@ -40,9 +98,15 @@ type Service interface {
 	ResolveProject(projectFlag string) (string, string, error)
 	LoadProject(name, path string) (*ProjectBundle, error)
 	SaveProject(bundle *ProjectBundle) error
-	Sync(token string) error
+	ResolveSecrets(bundle *ProjectBundle, env string) ([]Secret, error)
+	Sync(token string) (SyncResult, error)
+	PlanSync(token string) (SyncResult, error)
 	LoadSettings() (SettingsView, error)
-	ParseEnvContent(content string) ([]EnvPair, error)
-	RenderEnv(bundle *ProjectBundle) string
+	SetKeyStorage(kind, passphrase string) error
+	NormalizeEnv(name string) (string, error)
+	MachineLabel(id string) string
+	NormalizeMetadata(meta SecretMetadata) (SecretMetadata, error)
+	ParseImport(content, env string) ([]Secret, error)
+	RenderEnv(bundle *ProjectBundle, env string) (string, error)
 	RenderProjectJSON(bundle *ProjectBundle) (string, error)
 }
@@ -1,6 +1,9 @@
 package tui
 
-import "strings"
+import (
+	"errors"
+	"strings"
+)
 
 func (m model) renderFooterStatus(width int) string {
 	if m.hasActiveModal() {
@@ -18,3 +21,15 @@ func (m model) renderFooterHints(width int) string {
 	hints := m.renderFooter()
 	return m.styles.Footer.Width(width).Render(m.renderKeyHints(hints, width))
 }
+
+type hinter interface {
+	Hint() string
+}
+
+func errorStatus(err error) string {
+	var h hinter
+	if errors.As(err, &h) {
+		return err.Error() + " — " + h.Hint()
+	}
+	return err.Error()
+}
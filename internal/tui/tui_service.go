@@ -1,6 +1,10 @@
 package tui
 
-import appcore "github.com/jackhorton/veil/internal/app"
+import (
+	"errors"
+
+	appcore "github.com/jackhorton/veil/internal/app"
+)
 
 type tuiService struct {
 	app *appcore.App
@@ -46,8 +50,51 @@ func (s *tuiService) SaveProject(bundle *ProjectBundle) error {
 	return s.app.SaveProject(convertBundleFromTUI(bundle))
 }
 
-func (s *tuiService) Sync(token string) error {
-	return s.app.Sync(token)
+func (s *tuiService) ResolveSecrets(bundle *ProjectBundle, env string) ([]Secret, error) {
+	resolved, err := s.app.ResolveSecrets(convertBundleFromTUI(bundle), env)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Secret, 0, len(resolved))
+	for _, sec := range resolved {
+		converted := convertSecretToTUI(sec.Secret)
+		converted.Layer = sec.Layer
+		out = append(out, converted)
+	}
+	return out, nil
+}
+
+func (s *tuiService) Sync(token string) (SyncResult, error) {
+	report, err := s.app.Sync(appcore.SyncOptions{Token: token})
+	if err != nil {
+		return SyncResult{}, err
+	}
+	return convertSyncReport(report), nil
+}
+
+func (s *tuiService) PlanSync(token string) (SyncResult, error) {
+	report, err := s.app.Sync(appcore.SyncOptions{Token: token, DryRun: true})
+	var incomplete *appcore.SyncIncompleteError
+	if err != nil && !errors.As(err, &incomplete) {
+		return SyncResult{}, err
+	}
+	return convertSyncReport(report), nil
+}
+
+func convertSyncReport(report *appcore.SyncReport) SyncResult {
+	conflicts := make([]string, 0, len(report.Conflicts))
+	for _, c := range report.Conflicts {
+		conflicts = append(conflicts, c.Project+"/"+appcore.EnvKey(c.Env, c.Key))
+	}
+	plan := make([]SyncChange, 0, len(report.Plan))
+	for _, c := range report.Plan {
+		plan = append(plan, SyncChange{Project: c.Project, Key: appcore.EnvKey(c.Env, c.Key), Action: c.Action, Value: c.Value, Detail: c.Detail})
+	}
+	failed := make([]string, 0, len(report.Failed))
+	for _, f := range report.Failed {
+		failed = append(failed, f.Error())
+	}
+	return SyncResult{Pulled: len(report.Pulled), Conflicts: conflicts, Quarantined: len(report.Quarantined), Failed: failed, Warnings: report.Warnings, Plan: plan}
 }
 
 func (s *tuiService) LoadSettings() (SettingsView, error) {
@@ -55,59 +102,192 @@ func (s *tuiService) LoadSettings() (SettingsView, error) {
 	if err != nil {
 		return SettingsView{}, err
 	}
+	pending := make([]string, 0, len(config.Pending))
+	for _, p := range config.Pending {
+		pending = append(pending, p.PublicKey)
+	}
+	for _, p := range config.PendingSigners {
+		pending = append(pending, "signing key of machine "+p.MachineID)
+	}
+	machines, err := s.app.Machines()
+	if err != nil {
+		return SettingsView{}, err
+	}
+	status, err := s.app.Status()
+	if err != nil {
+		return SettingsView{}, err
+	}
+	machineViews := make([]MachineView, 0, len(machines))
+	for _, machine := range machines {
+		machineViews = append(machineViews, MachineView{
+			ID:         machine.ID,
+			Name:       machine.Name,
+			PublicKey:  machine.PublicKey,
+			Status:     machine.Status,
+			AddedAt:    machine.AddedAt,
+			LastSeenAt: machine.LastSeenAt,
+			Current:    machine.Current,
+		})
+	}
 	return SettingsView{
-		GistID:       config.Gist.ID,
-		LastSyncedAt: config.Gist.LastSyncedAt,
+		Remote:       config.Remote.String(),
+		LastSyncedAt: config.Remote.LastSyncedAt,
+		PendingPush:  status.PendingCount(),
 		MachineName:  config.Machine.Name,
 		KeyStorage:   config.KeyStorage,
 		ExportFormat: config.Prefs.ExportFormat,
+		Pending:      pending,
+		Machines:     machineViews,
+	}, nil
+}
+
+func (s *tuiService) SetKeyStorage(kind, passphrase string) error {
+	if passphrase != "" {
+		s.app.SetPassphrase(passphrase)
+	}
+	return s.app.MigrateKeyStorage(kind)
+}
+
+func (s *tuiService) NormalizeEnv(name string) (string, error) {
+	return appcore.NormalizeEnv(name)
+}
+
+func (s *tuiService) NormalizeMetadata(meta SecretMetadata) (SecretMetadata, error) {
+	normalized, err := appcore.NormalizeMetadata(appcore.SecretMetadata{
+		Description: meta.Description,
+		Tags:        meta.Tags,
+		Source:      meta.Source,
+		ExpiresAt:   meta.ExpiresAt,
+	})
+	if err != nil {
+		return SecretMetadata{}, err
+	}
+	return SecretMetadata{
+		Description: normalized.Description,
+		Tags:        normalized.Tags,
+		Source:      normalized.Source,
+		ExpiresAt:   normalized.ExpiresAt,
 	}, nil
 }
 
-func (s *tuiService) ParseEnvContent(content string) ([]EnvPair, error) {
-	pairs, err := appcore.ParseEnvContent(content)
+func (s *tuiService) ParseImport(content, env string) ([]Secret, error) {
+	secrets, err := appcore.ParseImport(content, env)
 	if err != nil {
 		return nil, err
 	}
-	out := make([]EnvPair, 0, len(pairs))
-	for _, p := range pairs {
-		out = append(out, EnvPair{Key: p.Key, Value: p.Value})
+	out := make([]Secret, 0, len(secrets))
+	for _, sec := range secrets {
+		out = append(out, convertSecretToTUI(sec))
 	}
 	return out, nil
 }
 
-func (s *tuiService) RenderEnv(bundle *ProjectBundle) string {
-	return appcore.RenderEnv(convertBundleFromTUI(bundle))
+func (s *tuiService) RenderEnv(bundle *ProjectBundle, env string) (string, error) {
+	view, err := s.app.ResolvedBundle(convertBundleFromTUI(bundle), env)
+	if err != nil {
+		return "", err
+	}
+	return appcore.RenderEnv(view), nil
 }
 
 func (s *tuiService) RenderProjectJSON(bundle *ProjectBundle) (string, error) {
 	return appcore.RenderProjectJSON(convertBundleFromTUI(bundle))
 }
 
+func (s *tuiService) MachineLabel(id string) string {
+	return s.app.MachineLabel(id)
+}
+
+func convertSecretToTUI(sec appcore.Secret) Secret {
+	var history []SecretVersion
+	for _, v := range sec.History {
+		history = append(history, SecretVersion{Value: v.Value, UpdatedAt: v.UpdatedAt, UpdatedBy: v.UpdatedBy})
+	}
+	return Secret{
+		Env:         sec.Env,
+		Key:         sec.Key,
+		Value:       sec.Value,
+		Group:       sec.Group,
+		Description: sec.Description,
+		Tags:        sec.Tags,
+		Source:      sec.Source,
+		ExpiresAt:   sec.ExpiresAt,
+		Expand:      sec.Expand,
+		CreatedAt:   sec.CreatedAt,
+		UpdatedAt:   sec.UpdatedAt,
+		UpdatedBy:   sec.UpdatedBy,
+		History:     history,
+	}
+}
+
+func convertSecretFromTUI(sec Secret) appcore.Secret {
+	var history []appcore.SecretVersion
+	for _, v := range sec.History {
+		history = append(history, appcore.SecretVersion{Value: v.Value, UpdatedAt: v.UpdatedAt, UpdatedBy: v.UpdatedBy})
+	}
+	return appcore.Secret{
+		Env:         sec.Env,
+		Key:         sec.Key,
+		Value:       sec.Value,
+		Group:       sec.Group,
+		Description: sec.Description,
+		Tags:        sec.Tags,
+		Source:      sec.Source,
+		ExpiresAt:   sec.ExpiresAt,
+		Expand:      sec.Expand,
+		CreatedAt:   sec.CreatedAt,
+		UpdatedAt:   sec.UpdatedAt,
+		UpdatedBy:   sec.UpdatedBy,
+		History:     history,
+	}
+}
+
 func convertBundleToTUI(bundle *appcore.ProjectBundle) *ProjectBundle {
 	secrets := make([]Secret, 0, len(bundle.Secrets))
 	for _, sec := range bundle.Secrets {
-		secrets = append(secrets, Secret{
-			Key:       sec.Key,
-			Value:     sec.Value,
-			Group:     sec.Group,
-			CreatedAt: sec.CreatedAt,
-			UpdatedAt: sec.UpdatedAt,
+		secrets = append(secrets, convertSecretToTUI(sec))
+	}
+	tombstones := make([]Tombstone, 0, len(bundle.Tombstones))
+	for _, t := range bundle.Tombstones {
+		tombstones = append(tombstones, Tombstone{
+			Env:       t.Env,
+			Key:       t.Key,
+			DeletedAt: t.DeletedAt,
+			MachineID: t.MachineID,
+			SeenBy:    t.SeenBy,
 		})
 	}
-	return &ProjectBundle{Project: bundle.Project, Path: bundle.Path, Secrets: secrets}
+	return &ProjectBundle{
+		Project:           bundle.Project,
+		Path:              bundle.Path,
+		Includes:          bundle.Includes,
+		IncludesUpdatedAt: bundle.IncludesUpdatedAt,
+		Secrets:           secrets,
+		Tombstones:        tombstones,
+	}
 }
 
 func convertBundleFromTUI(bundle *ProjectBundle) *appcore.ProjectBundle {
 	secrets := make([]appcore.Secret, 0, len(bundle.Secrets))
 	for _, sec := range bundle.Secrets {
-		secrets = append(secrets, appcore.Secret{
-			Key:       sec.Key,
-			Value:     sec.Value,
-			Group:     sec.Group,
-			CreatedAt: sec.CreatedAt,
-			UpdatedAt: sec.UpdatedAt,
+		secrets = append(secrets, convertSecretFromTUI(sec))
+	}
+	tombstones := make([]appcore.Tombstone, 0, len(bundle.Tombstones))
+	for _, t := range bundle.Tombstones {
+		tombstones = append(tombstones, appcore.Tombstone{
+			Env:       t.Env,
+			Key:       t.Key,
+			DeletedAt: t.DeletedAt,
+			MachineID: t.MachineID,
+			SeenBy:    t.SeenBy,
 		})
 	}
-	return &appcore.ProjectBundle{Project: bundle.Project, Path: bundle.Path, Secrets: secrets}
+	return &appcore.ProjectBundle{
+		Project:           bundle.Project,
+		Path:              bundle.Path,
+		Includes:          bundle.Includes,
+		IncludesUpdatedAt: bundle.IncludesUpdatedAt,
+		Secrets:           secrets,
+		Tombstones:        tombstones,
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
@@ -40,7 +41,45 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	}
 
-	if m.mode == modeAddKey || m.mode == modeAddValue || m.mode == modeEditValue || m.mode == modeFilter || m.mode == modeImportPath || m.mode == modeExportPath {
+	if m.mode == modeSyncPreview {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			switch keyMsg.String() {
+			case "enter":
+				m.mode = modeNormal
+				m.syncPlan = SyncResult{}
+				m.runSync()
+			case "esc":
+				m.mode = modeNormal
+				m.syncPlan = SyncResult{}
+				m.status = "Sync cancelled"
+			}
+			return m, nil
+		}
+	}
+
+	if m.mode == modeHistory {
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			switch keyMsg.String() {
+			case "up", "k":
+				if m.historyCursor > 0 {
+					m.historyCursor--
+				}
+			case "down", "j":
+				if m.historyCursor < len(m.history)-1 {
+					m.historyCursor++
+				}
+			case "enter":
+				m.restoreVersion()
+			case "esc":
+				m.mode = modeNormal
+				m.history = nil
+				m.status = "Ready"
+			}
+			return m, nil
+		}
+	}
+
+	if m.mode == modeAddKey || m.mode == modeAddValue || m.mode == modeEditValue || m.mode == modeFilter || m.mode == modeImportPath || m.mode == modeExportPath || m.mode == modeKeyStorage || m.mode == modeKeyPassphrase || m.mode == modeKeyPassphraseConfirm || m.mode == modeEnvSelect {
 		var cmd tea.Cmd
 		m.input, cmd = m.input.Update(msg)
 		switch keyMsg := msg.(type) {
@@ -48,13 +87,24 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch keyMsg.String() {
 			case "esc":
 				m.mode = modeNormal
+				m.passphrase = ""
 				m.resetInputForPage()
 				m.status = "Cancelled"
+			case "tab", "shift+tab":
+				if m.mode != modeEditValue {
+					break
+				}
+				step := 1
+				if keyMsg.String() == "shift+tab" {
+					step = len(editFieldNames) - 1
+				}
+				m.editValues[m.editField] = m.input.Value()
+				m.focusEditField((m.editField + step) % len(editFieldNames))
 			case "enter":
 				switch m.mode {
 				case modeAddKey:
 					if err := m.ensureCurrentBundle(); err != nil {
-						m.status = err.Error()
+						m.status = errorStatus(err)
 						m.mode = modeNormal
 						m.resetInputForPage()
 						return m, cmd
@@ -66,9 +116,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						m.status = "Use format KEY=VALUE"
 						return m, cmd
 					}
-					upsertSecret(m.bundle, key, value, "")
+					upsertSecret(m.bundle, m.env, key, value, "")
 					if err := m.svc.SaveProject(m.bundle); err != nil {
-						m.status = err.Error()
+						m.status = errorStatus(err)
 					} else {
 						m.status = formatSavedStatus(key)
 						m.load()
@@ -85,9 +135,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						m.resetInputForPage()
 						break
 					}
-					upsertSecret(m.bundle, m.pendingKey, value, "")
+					upsertSecret(m.bundle, m.env, m.pendingKey, value, "")
 					if err := m.svc.SaveProject(m.bundle); err != nil {
-						m.status = err.Error()
+						m.status = errorStatus(err)
 					} else {
 						m.status = formatSavedStatus(m.pendingKey)
 						m.load()
@@ -97,16 +147,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.mode = modeNormal
 					m.resetInputForPage()
 				case modeEditValue:
-					value := m.input.Value()
 					if m.bundle == nil || m.pendingKey == "" {
 						m.status = "Nothing selected"
 						m.mode = modeNormal
 						m.resetInputForPage()
 						break
 					}
-					upsertSecret(m.bundle, m.pendingKey, value, "")
+					m.editValues[m.editField] = m.input.Value()
+					meta, err := m.svc.NormalizeMetadata(SecretMetadata{
+						Description: m.editValues[1],
+						Tags:        []string{m.editValues[2]},
+						Source:      m.editValues[3],
+						ExpiresAt:   m.editValues[4],
+					})
+					if err != nil {
+						m.status = errorStatus(err)
+						break
+					}
+					upsertSecret(m.bundle, m.env, m.pendingKey, m.editValues[0], "")
+					setSecretMetadata(m.bundle, m.env, m.pendingKey, meta)
 					if err := m.svc.SaveProject(m.bundle); err != nil {
-						m.status = err.Error()
+						m.status = errorStatus(err)
 					} else {
 						m.status = "Updated secret " + m.pendingKey
 						m.load()
@@ -129,21 +190,23 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 					raw, err := os.ReadFile(path)
 					if err != nil {
-						m.status = err.Error()
+						m.status = errorStatus(err)
 						break
 					}
-					pairs, err := m.svc.ParseEnvContent(string(raw))
+					secrets, err := m.svc.ParseImport(string(raw), m.env)
 					if err != nil {
-						m.status = err.Error()
+						m.status = errorStatus(err)
 						break
 					}
-					for _, pair := range pairs {
-						upsertSecret(m.bundle, pair.Key, pair.Value, "")
+					if err := m.importSecrets(secrets); err != nil {
+						m.status = errorStatus(err)
+						m.load()
+						break
 					}
 					if err := m.svc.SaveProject(m.bundle); err != nil {
-						m.status = err.Error()
+						m.status = errorStatus(err)
 					} else {
-						m.status = fmt.Sprintf("Imported %d keys", len(pairs))
+						m.status = fmt.Sprintf("Imported %d keys", len(secrets))
 						m.load()
 					}
 					m.mode = modeNormal
@@ -154,22 +217,75 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						m.status = "Export path is required"
 						break
 					}
-					output := m.svc.RenderEnv(m.bundle)
+					var output string
+					var err error
 					if strings.HasSuffix(strings.ToLower(path), ".json") {
-						jsonOutput, err := m.svc.RenderProjectJSON(m.bundle)
-						if err != nil {
-							m.status = err.Error()
-							break
-						}
-						output = jsonOutput
+						output, err = m.svc.RenderProjectJSON(m.bundle)
+					} else {
+						output, err = m.svc.RenderEnv(m.bundle, m.env)
+					}
+					if err != nil {
+						m.status = errorStatus(err)
+						break
 					}
 					if err := os.WriteFile(path, []byte(output), 0o600); err != nil {
-						m.status = err.Error()
+						m.status = errorStatus(err)
 						break
 					}
 					m.mode = modeNormal
 					m.resetInputForPage()
 					m.status = "Exported to " + path
+				case modeEnvSelect:
+					env, err := m.svc.NormalizeEnv(m.input.Value())
+					if err != nil {
+						m.status = errorStatus(err)
+						break
+					}
+					m.env = env
+					m.revealKey = ""
+					m.pendingReveal = ""
+					m.pendingDelete = ""
+					m.mode = modeNormal
+					m.resetInputForPage()
+					m.status = "Showing environment " + envLabel(env)
+					m.resolve()
+				case modeKeyStorage:
+					kind := strings.ToLower(strings.TrimSpace(m.input.Value()))
+					if kind == "passphrase" {
+						m.mode = modeKeyPassphrase
+						m.input.Prompt = "passphrase> "
+						m.input.SetValue("")
+						m.input.EchoMode = textinput.EchoPassword
+						m.status = "Enter a passphrase for the identity"
+						break
+					}
+					m.mode = modeNormal
+					m.resetInputForPage()
+					m.status = m.setKeyStorage(kind, "")
+				case modeKeyPassphrase:
+					passphrase := m.input.Value()
+					if passphrase == "" {
+						m.status = "Passphrase cannot be empty"
+						break
+					}
+					m.passphrase = passphrase
+					m.mode = modeKeyPassphraseConfirm
+					m.input.Prompt = "confirm> "
+					m.input.SetValue("")
+					m.status = "Enter the passphrase again"
+				case modeKeyPassphraseConfirm:
+					passphrase := m.passphrase
+					m.passphrase = ""
+					if m.input.Value() != passphrase {
+						m.mode = modeKeyPassphrase
+						m.input.Prompt = "passphrase> "
+						m.input.SetValue("")
+						m.status = "Passphrases do not match; enter a new one"
+						break
+					}
+					m.mode = modeNormal
+					m.resetInputForPage()
+					m.status = m.setKeyStorage("passphrase", passphrase)
 				}
 			}
 		}
@@ -203,7 +319,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "i":
 			if m.needsInit {
 				if err := m.svc.Init("file", ""); err != nil {
-					m.status = err.Error()
+					m.status = errorStatus(err)
 				} else {
 					m.status = "Initialized with file key storage"
 					m.load()
@@ -214,7 +330,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				break
 			}
 			if err := m.ensureCurrentBundle(); err != nil {
-				m.status = err.Error()
+				m.status = errorStatus(err)
 				break
 			}
 			m.mode = modeImportPath
@@ -225,28 +341,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "k":
 			if m.needsInit {
 				if err := m.svc.Init("keychain", ""); err != nil {
-					m.status = err.Error()
+					m.status = errorStatus(err)
 				} else {
 					m.status = "Initialized with keychain key storage"
 					m.load()
 				}
 			}
+		case "K":
+			if m.page != pageSettings || m.needsInit {
+				break
+			}
+			current := ""
+			if settings, err := m.svc.LoadSettings(); err == nil {
+				current = settings.KeyStorage
+			}
+			m.mode = modeKeyStorage
+			m.input.Prompt = "key storage (file/keychain/passphrase)> "
+			m.input.SetValue(current)
+			m.input.Focus()
+			m.status = "Choose key storage backend"
 		case "S":
 			if m.needsInit {
 				break
 			}
-			if err := m.svc.Sync(""); err != nil {
-				m.status = err.Error()
-			} else {
-				m.status = "Synced"
-				m.load()
+			plan, err := m.svc.PlanSync("")
+			if err != nil {
+				m.status = errorStatus(err)
+				break
 			}
+			m.syncPlan = plan
+			m.mode = modeSyncPreview
+			m.status = "Review the sync plan"
 		case "a":
 			if m.needsInit {
 				break
 			}
 			if err := m.ensureCurrentBundle(); err != nil {
-				m.status = err.Error()
+				m.status = errorStatus(err)
 				break
 			}
 			if m.page == pageHome || m.page == pageProject {
@@ -256,25 +387,44 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.input.Focus()
 				m.status = "Enter KEY=VALUE"
 			}
-		case "e":
-			if m.page != pageProject || m.bundle == nil {
+		case "E":
+			if m.page != pageProject || m.bundle == nil || m.needsInit {
 				break
 			}
-			row := m.projectTable.SelectedRow()
-			if len(row) < 2 {
+			m.mode = modeEnvSelect
+			m.input.Prompt = "environment> "
+			m.input.SetValue(envLabel(m.env))
+			m.input.Focus()
+			m.status = "Choose an environment"
+		case "e":
+			if m.page != pageProject || m.bundle == nil {
 				break
 			}
-			selected, ok := getSecret(m.bundle, row[1])
+			selected, ok := m.selectedSecret()
 			if !ok {
 				break
 			}
 			m.pendingKey = selected.Key
 			m.pendingValue = selected.Value
+			m.editValues = []string{selected.Value, selected.Description, strings.Join(selected.Tags, ", "), selected.Source, expiryInput(selected.ExpiresAt)}
 			m.mode = modeEditValue
-			m.input.Prompt = "value> "
-			m.input.SetValue(selected.Value)
+			m.focusEditField(0)
 			m.input.Focus()
-			m.status = "Edit value for " + selected.Key
+			m.status = "Edit " + selected.Key
+		case "h":
+			if m.page != pageProject || m.bundle == nil {
+				break
+			}
+			selected, ok := m.selectedSecret()
+			if !ok {
+				break
+			}
+			m.history = secretVersions(selected)
+			m.historyKey = selected.Key
+			m.historyLayer = selected.Layer
+			m.historyCursor = 0
+			m.mode = modeHistory
+			m.status = fmt.Sprintf("%s has %d earlier version(s)", selected.Key, len(m.history)-1)
 		case "x":
 			if m.page != pageProject || m.bundle == nil || m.needsInit {
 				break
@@ -329,11 +479,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				break
 			}
 			m.pendingDelete = ""
-			if !removeSecret(m.bundle, row[1]) {
+			if !removeSecret(m.bundle, m.env, row[1]) {
+				if selected, ok := m.selectedSecret(); ok {
+					m.status = row[1] + " comes from " + selected.Layer + "; delete it there"
+				}
 				break
 			}
 			if err := m.svc.SaveProject(m.bundle); err != nil {
-				m.status = err.Error()
+				m.status = errorStatus(err)
 			} else {
 				m.status = "Deleted " + row[1]
 				if m.revealKey == row[1] {
@@ -352,3 +505,79 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	return m, nil
 }
+
+func (m *model) runSync() {
+	result, err := m.svc.Sync("")
+	if err != nil {
+		m.status = errorStatus(err)
+		m.load()
+		return
+	}
+	if len(result.Warnings) > 0 {
+		m.status = "Warning: " + result.Warnings[0]
+	} else if result.Quarantined > 0 {
+		m.status = fmt.Sprintf("Warning: quarantined %d untrusted remote bundles (see `veil machines`)", result.Quarantined)
+	} else if len(result.Conflicts) > 0 {
+		m.status = fmt.Sprintf("Warning: synced with %d conflicts: %s", len(result.Conflicts), strings.Join(result.Conflicts, ", "))
+	} else {
+		m.status = "Synced"
+	}
+	m.load()
+}
+
+var editFieldNames = []string{"value", "description", "tags", "source", "expires"}
+
+func (m *model) focusEditField(field int) {
+	m.editField = field
+	m.input.Prompt = editFieldNames[field] + "> "
+	m.input.SetValue(m.editValues[field])
+	m.input.CursorEnd()
+}
+
+// importSecrets writes each secret into its own environment: the one shown for
+// a .env file, the exported one for a JSON export.
+func (m *model) importSecrets(secrets []Secret) error {
+	for _, secret := range secrets {
+		upsertSecret(m.bundle, secret.Env, secret.Key, secret.Value, secret.Group)
+		existing, _ := getSecret(m.bundle, secret.Env, secret.Key)
+		meta, err := m.svc.NormalizeMetadata(importedMetadata(existing, secret))
+		if err != nil {
+			return fmt.Errorf("%s: %w", secret.Key, err)
+		}
+		setSecretMetadata(m.bundle, secret.Env, secret.Key, meta)
+		if secret.Expand {
+			setSecretExpand(m.bundle, secret.Env, secret.Key)
+		}
+	}
+	return nil
+}
+
+// restoreVersion writes the highlighted version back as a new edit, so the
+// value it replaces stays in history.
+func (m *model) restoreVersion() {
+	key, cursor, versions := m.historyKey, m.historyCursor, m.history
+	m.mode = modeNormal
+	m.history = nil
+	switch {
+	case cursor == 0:
+		m.status = key + " already has this value"
+		return
+	case m.historyLayer != layerName(m.bundle.Project, m.env):
+		m.status = key + " comes from " + m.historyLayer + "; restore it there"
+		return
+	}
+	upsertSecret(m.bundle, m.env, key, versions[cursor].Value, "")
+	if err := m.svc.SaveProject(m.bundle); err != nil {
+		m.status = errorStatus(err)
+		return
+	}
+	m.status = fmt.Sprintf("Restored %s to version %d", key, cursor)
+	m.load()
+}
+
+func (m *model) setKeyStorage(kind, passphrase string) string {
+	if err := m.svc.SetKeyStorage(kind, passphrase); err != nil {
+		return errorStatus(err)
+	}
+	return "Identity now stored in " + kind
+}
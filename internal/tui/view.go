@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 )
@@ -58,7 +59,7 @@ func (m model) renderHome() string {
 	var parts []string
 
 	if m.bundle != nil && len(m.bundle.Secrets) > 0 {
-		recent := append([]Secret(nil), m.bundle.Secrets...)
+		recent := append([]Secret(nil), m.resolved...)
 		sort.Slice(recent, func(i, j int) bool { return recent[i].UpdatedAt > recent[j].UpdatedAt })
 		limit := 3
 		if len(recent) < limit {
@@ -73,14 +74,19 @@ func (m model) renderHome() string {
 
 	syncStatus := "not linked"
 	if settings, err := m.svc.LoadSettings(); err == nil {
-		if settings.GistID != "" {
-			syncStatus = "never"
-			if settings.LastSyncedAt != "" {
-				syncStatus = settings.LastSyncedAt
-			}
+		switch {
+		case settings.Remote == "":
+		case settings.PendingPush == 1:
+			syncStatus = "1 project pending push"
+		case settings.PendingPush > 1:
+			syncStatus = fmt.Sprintf("%d projects pending push", settings.PendingPush)
+		case settings.LastSyncedAt == "":
+			syncStatus = "never synced"
+		default:
+			syncStatus = "all projects pushed"
 		}
 	}
-	parts = append(parts, m.styles.Muted.Render("  synced "+syncStatus))
+	parts = append(parts, m.styles.Muted.Render("  "+syncStatus))
 
 	content := strings.Join(parts, "\n")
 	panelWidth := 72
@@ -98,19 +104,34 @@ func (m model) renderProject() string {
 	var parts []string
 
 	if m.current != "" {
-		info := fmt.Sprintf("  Project: %s", m.current)
+		info := fmt.Sprintf("  Project: %s · env %s", m.current, envLabel(m.env))
 		if m.bundle != nil {
-			info += fmt.Sprintf(" · %d secrets", len(m.bundle.Secrets))
+			own := 0
+			for _, secret := range m.resolved {
+				if secret.Layer == layerName(m.current, m.env) {
+					own++
+				}
+			}
+			info += fmt.Sprintf(" · %d secrets", len(m.resolved))
+			if inherited := len(m.resolved) - own; inherited > 0 {
+				info += fmt.Sprintf(" (%d inherited)", inherited)
+			}
+			if len(m.bundle.Includes) > 0 {
+				info += " · includes " + strings.Join(m.bundle.Includes, ", ")
+			}
 		}
 		parts = append(parts, m.styles.Muted.Render(info))
 		parts = append(parts, "")
 	}
 
 	body := m.projectTable.View()
-	if m.bundle == nil || len(m.bundle.Secrets) == 0 {
+	if m.bundle == nil || len(m.resolved) == 0 {
 		body = "  No secrets"
 	}
 	parts = append(parts, m.renderSectionTitle("Secrets", m.innerWidth()), body)
+	if selected, ok := m.selectedSecret(); ok {
+		parts = append(parts, m.styles.Muted.Render("  "+secretDetails(selected, time.Now())))
+	}
 
 	return strings.Join(parts, "\n")
 }
@@ -118,24 +139,46 @@ func (m model) renderProject() string {
 func (m model) renderSettings() string {
 	settings, err := m.svc.LoadSettings()
 	if err != nil {
-		return "  Failed to load settings: " + err.Error()
+		return "  Failed to load settings: " + errorStatus(err)
 	}
-	gist := "Not linked"
-	if settings.GistID != "" {
-		gist = settings.GistID
+	remote := "Not linked"
+	if settings.Remote != "" {
+		remote = settings.Remote
 	}
 	syncStatus := settings.LastSyncedAt
 	if syncStatus == "" {
 		syncStatus = "never"
 	}
-	content := strings.Join([]string{
+	lines := []string{
 		m.renderSectionTitle("Configuration", m.innerWidth()),
-		"  GitHub Gist: " + gist,
+		"  Remote: " + remote,
 		"  Last Sync: " + syncStatus,
+		fmt.Sprintf("  Pending Push: %d project(s)", settings.PendingPush),
 		"  Machine: " + settings.MachineName,
 		"  Key Storage: " + settings.KeyStorage,
 		"  Export Default: " + settings.ExportFormat,
-	}, "\n")
+	}
+	if len(settings.Pending) > 0 {
+		lines = append(lines, "", m.styles.Warn.Render(fmt.Sprintf("  ! %d untrusted machine key(s) found on the remote", len(settings.Pending))))
+		for _, key := range settings.Pending {
+			lines = append(lines, m.styles.Muted.Render("    "+key))
+		}
+		lines = append(lines, m.styles.Muted.Render("  Secrets are not shared with them until you run `veil machines approve`"))
+	}
+	lines = append(lines, "", m.renderSectionTitle("Machines", m.innerWidth()))
+	for _, machine := range settings.Machines {
+		name := machine.Name
+		if machine.Current {
+			name += " (this machine)"
+		}
+		lastSeen := machine.LastSeenAt
+		if lastSeen == "" {
+			lastSeen = "never"
+		}
+		lines = append(lines, fmt.Sprintf("  %s · %s · %s", name, machine.Status, shortKey(machine.PublicKey)))
+		lines = append(lines, m.styles.Muted.Render(fmt.Sprintf("    id %s · added %s · last seen %s", machine.ID, machine.AddedAt, lastSeen)))
+	}
+	content := strings.Join(lines, "\n")
 	return m.styles.Panel.Width(max(48, m.innerWidth()-2)).Render(content)
 }
 
@@ -143,6 +186,8 @@ func (m model) renderFooter() string {
 	var help string
 	if m.mode == modePageSelect {
 		help = "[1] home  [2] project  [3] settings  [esc] cancel"
+	} else if m.mode == modeHistory {
+		help = "[↑/↓] select  [enter] restore  [esc] close"
 	} else if m.mode != modeNormal {
 		help = "[enter] confirm  [esc] cancel"
 	} else {
@@ -150,10 +195,85 @@ func (m model) renderFooter() string {
 		case pageHome:
 			help = "[a] add  [i] import  [S] sync  [l] list  [P] pages  [q] quit"
 		case pageProject:
-			help = "[a] add  [e] edit  [d] delete  [r] reveal  [/] filter  [i] import  [x] export  [S] sync  [P] pages  [q] quit"
+			help = "[a] add  [e] edit  [d] delete  [r] reveal  [h] history  [E] env  [/] filter  [i] import  [x] export  [S] sync  [P] pages  [q] quit"
 		case pageSettings:
-			help = "[S] sync  [P] pages  [q] quit"
+			help = "[K] key storage  [S] sync  [P] pages  [q] quit"
 		}
 	}
 	return help
 }
+
+const maxPlanLines = 12
+
+func (m model) renderSyncPlan() string {
+	plan := m.syncPlan.Plan
+	lines := []string{}
+	for _, failure := range m.syncPlan.Failed {
+		lines = append(lines, m.styles.Warn.Render("! "+failure+" (will not be synced)"))
+	}
+	if m.syncPlan.Quarantined > 0 {
+		lines = append(lines, m.styles.Warn.Render(fmt.Sprintf("! %d untrusted remote bundle(s) would be quarantined", m.syncPlan.Quarantined)))
+	}
+	if len(plan) == 0 {
+		lines = append(lines, "No secret changes; sync will refresh machines and recipients")
+	}
+	project := ""
+	for i, change := range plan {
+		if len(lines) >= maxPlanLines {
+			lines = append(lines, m.styles.Muted.Render(fmt.Sprintf("… and %d more", len(plan)-i)))
+			break
+		}
+		if change.Project != project {
+			project = change.Project
+			lines = append(lines, m.styles.Text.Render(project))
+		}
+		line := fmt.Sprintf("  %-9s %s", change.Action, shortKey(change.Key))
+		if change.Value != "" {
+			line += "=" + change.Value
+		}
+		if change.Detail != "" {
+			line += " (" + change.Detail + ")"
+		}
+		if change.Action == "conflict" {
+			lines = append(lines, m.styles.Warn.Render(line))
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m model) renderHistory() string {
+	start := 0
+	if m.historyCursor >= maxPlanLines {
+		start = m.historyCursor - maxPlanLines + 1
+	}
+	lines := []string{}
+	for i := start; i < len(m.history) && i < start+maxPlanLines; i++ {
+		version := m.history[i]
+		machine := m.svc.MachineLabel(version.UpdatedBy)
+		if machine == "" {
+			machine = "-"
+		}
+		line := fmt.Sprintf("%2d  %-20s  %-12s  %s", i, version.UpdatedAt, machine, maskValue(version.Value))
+		if i == 0 {
+			line += " (current)"
+		}
+		if i == m.historyCursor {
+			lines = append(lines, m.styles.Text.Render("› "+line))
+			continue
+		}
+		lines = append(lines, m.styles.Muted.Render("  "+line))
+	}
+	if len(m.history) == 1 {
+		lines = append(lines, m.styles.Muted.Render("No earlier versions"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func shortKey(key string) string {
+	if len(key) <= 20 {
+		return key
+	}
+	return key[:16] + "…"
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+
+	appcore "github.com/jackhorton/veil/internal/app"
 )
 
 func main() {
 	if err := runCLI(os.Args[1:]); err != nil {
 		fmt.Fprintln(os.Stderr, "veil:", err)
+		var idErr *appcore.IdentityError
+		if errors.As(err, &idErr) {
+			fmt.Fprintln(os.Stderr, "hint:", idErr.Hint())
+			fmt.Fprintln(os.Stderr, "Run `veil doctor` for a full check")
+		}
 		os.Exit(1)
 	}
 }